@@ -0,0 +1,49 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Execer executes SQL, as *sql.DB, *sql.Conn and *sql.Tx do.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Backfill runs a batched UPDATE or DELETE until it affects fewer rows than
+// batchSize, calling progress with the total rows processed after each
+// batch. query must process at most $1 rows per call and exclude rows
+// already processed, or the loop never ends, e.g.
+//
+//	UPDATE users SET email_lower = lower(email)
+//	WHERE id IN (SELECT id FROM users WHERE email_lower IS NULL LIMIT $1)
+//
+// Each batch is its own statement, so with a *sql.DB every batch commits on
+// its own and locks are held only briefly; with a *sql.Tx, such as inside a
+// migration's transaction, all batches commit together. progress may be nil.
+func Backfill(ctx context.Context, db Execer, query string, batchSize int, progress func(done int)) error {
+	if batchSize <= 0 {
+		return errors.New("batch size must be positive")
+	}
+
+	done := 0
+	for {
+		res, err := db.ExecContext(ctx, query, batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to run batch after %d rows: %w", done, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to count batch rows: %w", err)
+		}
+		done += int(n)
+		if progress != nil {
+			progress(done)
+		}
+		if n < int64(batchSize) {
+			return nil
+		}
+	}
+}
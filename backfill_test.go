@@ -0,0 +1,61 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// fakeExecer processes up to the batch size of remaining rows per call.
+type fakeExecer struct {
+	remaining int
+	calls     int
+	err       error
+}
+
+func (f *fakeExecer) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	n := min(f.remaining, args[0].(int))
+	f.remaining -= n
+	return fakeResult(n), nil
+}
+
+type fakeResult int64
+
+func (r fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+func TestBackfill(t *testing.T) {
+	db := &fakeExecer{remaining: 25}
+	var progress []int
+	if err := Backfill(context.Background(), db, "UPDATE t ...", 10, func(done int) {
+		progress = append(progress, done)
+	}); err != nil {
+		t.Fatalf("failed to backfill: %v", err)
+	}
+	if want := []int{10, 20, 25}; !slices.Equal(progress, want) {
+		t.Fatalf("expected progress %v, got %v", want, progress)
+	}
+
+	// An exact multiple of the batch size needs one final empty batch.
+	db = &fakeExecer{remaining: 20}
+	if err := Backfill(context.Background(), db, "UPDATE t ...", 10, nil); err != nil {
+		t.Fatalf("failed to backfill: %v", err)
+	}
+	if db.calls != 3 {
+		t.Fatalf("expected 3 batches, got %d", db.calls)
+	}
+
+	if err := Backfill(context.Background(), &fakeExecer{}, "UPDATE t ...", 0, nil); err == nil {
+		t.Fatal("expected error for a zero batch size")
+	}
+	cause := errors.New("deadlock")
+	if err := Backfill(context.Background(), &fakeExecer{err: cause}, "UPDATE t ...", 10, nil); !errors.Is(err, cause) {
+		t.Fatalf("expected batch error, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// recordedChecksums returns the checksum stored for each applied version.
+// Versions recorded before checksums were tracked are left out.
+func (m *Migrator) recordedChecksums(ctx context.Context, tx *sql.Tx) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT version, checksum FROM %s WHERE checksum IS NOT NULL AND checksum <> ''", m.table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recorded checksums: %w", err)
+	}
+	defer rows.Close()
+
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var version, checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, fmt.Errorf("failed to get recorded checksums: %w", err)
+		}
+		checksums[version] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// verifyChecksums fails with ErrChecksumMismatch when an applied migration
+// file has changed since it was recorded. A renamed file is compared with the
+// checksum recorded under its old version, which renamed maps it to.
+func (m *Migrator) verifyChecksums(ctx context.Context, tx *sql.Tx, files []string, renamed map[string]string) error {
+	recorded, err := m.recordedChecksums(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		version := versionOf(file)
+		if old, ok := renamed[version]; ok {
+			version = old
+		}
+		want, ok := recorded[version]
+		if !ok {
+			continue
+		}
+		mig, err := m.loadMigration(file)
+		if err != nil {
+			return err
+		}
+		if mig.checksum != want {
+			return fmt.Errorf("%w: migration %s was changed after it was applied; run Recheck if the edit was intentional", ErrChecksumMismatch, mig.version)
+		}
+	}
+	return nil
+}
+
+// verifyRenames fails with ErrChecksumMismatch unless each file in renamed
+// has the checksum recorded under the applied version it replaces, so an
+// unrelated file that shares the version key of a deleted migration is not
+// taken for it and skipped.
+// Without checksums, as with a custom tracker, no rename can be confirmed.
+func (m *Migrator) verifyRenames(ctx context.Context, tx *sql.Tx, files []string, renamed map[string]string) error {
+	if len(renamed) == 0 {
+		return nil
+	}
+	recorded := map[string]string{}
+	if m.cfg.tracker == nil {
+		var err error
+		if recorded, err = m.recordedChecksums(ctx, tx); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		old, ok := renamed[versionOf(file)]
+		if !ok {
+			continue
+		}
+		mig, err := m.loadMigration(file)
+		if err != nil {
+			return err
+		}
+		if mig.checksum != recorded[old] {
+			return fmt.Errorf("%w: migration %s shares the version key of applied migration %s, which has no file, but not its checksum; restore %s, give %s a new version, or run Recheck on %s if it is %s renamed and edited",
+				ErrChecksumMismatch, mig.version, old, old, mig.version, mig.version, old)
+		}
+	}
+	return nil
+}
+
+// Recheck updates the checksum recorded for an applied migration to match its
+// current file, after an edit that does not need to run again, such as fixing
+// a typo in a comment. It is the way past WithVerifyChecksums for a known
+// change, so the update is logged as a warning and sent to WithAuditSink.
+// Nothing is written when the checksum already matches.
+func (m *Migrator) Recheck(ctx context.Context, version string) error {
+	if m.cfg.tracker != nil {
+		return fmt.Errorf("recheck requires the default tracker")
+	}
+
+	var old, updated string
+	err := m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+
+		// Run does not take a file renamed and edited since it was applied
+		// for the applied migration; naming it here records the rename.
+		applied, err := m.getAppliedMigrations(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+		renamed, err := renamedVersions(m.cfg.scheme, files, applied)
+		if err != nil {
+			return err
+		}
+		if old, ok := renamed[version]; ok {
+			if err := m.recordRename(ctx, tx, old, version); err != nil {
+				return err
+			}
+		}
+
+		var recorded sql.NullString
+		err = tx.QueryRowContext(ctx, fmt.Sprintf("SELECT checksum FROM %s WHERE version = $1", m.table), version).Scan(&recorded)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("migration %s is not applied", version)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get recorded checksum: %w", err)
+		}
+
+		for _, file := range files {
+			if versionOf(file) != version {
+				continue
+			}
+			mig, err := m.loadMigration(file)
+			if err != nil {
+				return err
+			}
+			if mig.checksum == recorded.String {
+				return nil
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET checksum = $1 WHERE version = $2", m.table), mig.checksum, version); err != nil {
+				return fmt.Errorf("failed to update checksum: %w", err)
+			}
+			old, updated = recorded.String, mig.checksum
+			return nil
+		}
+		return fmt.Errorf("migration %s not found", version)
+	})
+	if err != nil || updated == "" {
+		return err
+	}
+
+	m.cfg.logger.Warn("rechecked migration", "version", version, "old_checksum", old, "checksum", updated, "applied_by", m.cfg.appliedBy)
+	if m.cfg.auditSink != nil {
+		m.cfg.auditSink(version, fmt.Sprintf("-- recheck: checksum changed from %s to %s", old, updated))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ErrorAction is what WithErrorClassifier decides to do about a failed
+// migration.
+type ErrorAction int
+
+const (
+	// ErrorFail fails the run, as without a classifier.
+	ErrorFail ErrorAction = iota
+
+	// ErrorIgnore undoes the migration and records it as applied, e.g. for
+	// "already exists" errors while adopting a database that has some of
+	// the schema.
+	ErrorIgnore
+
+	// ErrorRetry undoes the migration and runs it again, up to
+	// maxClassifiedRetries times before the run fails.
+	ErrorRetry
+)
+
+// maxClassifiedRetries bounds how often a classifier can retry one migration.
+const maxClassifiedRetries = 3
+
+// classifierSavepoint names the savepoint taken around each migration while
+// a classifier is set. Migrations that use their own savepoints should pick
+// other names.
+const classifierSavepoint = "migrator_migration"
+
+// applyClassified applies mig within a savepoint, so a failure the
+// WithErrorClassifier function ignores or retries can be undone without
+// aborting the run's transaction.
+func (m *Migrator) applyClassified(ctx context.Context, tx *sql.Tx, mig *migration) error {
+	if m.cfg.errorClassifier == nil {
+		return m.applyMigration(ctx, tx, mig)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT "+classifierSavepoint); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		err := m.applyMigration(ctx, tx, mig)
+		if err == nil {
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+classifierSavepoint); err != nil {
+				return fmt.Errorf("failed to release savepoint: %w", err)
+			}
+			return nil
+		}
+
+		action := m.cfg.errorClassifier(mig.version, err)
+		switch action {
+		case ErrorIgnore:
+		case ErrorRetry:
+			if attempt > maxClassifiedRetries {
+				return err
+			}
+		default:
+			return err
+		}
+		if _, rollbackErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+classifierSavepoint); rollbackErr != nil {
+			return fmt.Errorf("failed to roll back to savepoint: %w", rollbackErr)
+		}
+		mig.executed = nil
+
+		if action == ErrorIgnore {
+			m.cfg.logger.Warn("ignored migration error", "version", mig.version, "error", err)
+			return m.recordMigration(ctx, tx, mig, false)
+		}
+		m.cfg.logger.Warn("retrying migration", "version", mig.version, "attempt", attempt, "error", err)
+	}
+}
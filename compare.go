@@ -0,0 +1,152 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"sort"
+)
+
+// CompareResult lists the differences between two migration sets by
+// version, each in migration order.
+type CompareResult struct {
+	// OnlyHere lists versions found only in the Migrator's migrations.
+	OnlyHere []string
+	// OnlyInOther lists versions found only in the other migrations.
+	OnlyInOther []string
+	// Changed lists versions in both sets whose checksums differ.
+	Changed []string
+}
+
+// CompareTo compares the Migrator's migrations with other, read with the
+// same options. It only reads files and never connects to the database.
+func (m *Migrator) CompareTo(other fs.FS) (CompareResult, error) {
+	theirs := *m
+	theirs.migrations = other
+
+	here, hereOrder, err := m.checksums()
+	if err != nil {
+		return CompareResult{}, err
+	}
+	there, thereOrder, err := theirs.checksums()
+	if err != nil {
+		return CompareResult{}, err
+	}
+
+	var result CompareResult
+	for _, version := range hereOrder {
+		checksum, ok := there[version]
+		switch {
+		case !ok:
+			result.OnlyHere = append(result.OnlyHere, version)
+		case checksum != here[version]:
+			result.Changed = append(result.Changed, version)
+		}
+	}
+	for _, version := range thereOrder {
+		if _, ok := here[version]; !ok {
+			result.OnlyInOther = append(result.OnlyInOther, version)
+		}
+	}
+	return result, nil
+}
+
+// checksums returns the checksum of every migration by version, and the
+// versions in migration order.
+func (m *Migrator) checksums() (map[string]string, []string, error) {
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get migration files: %w", err)
+	}
+
+	checksums := make(map[string]string, len(files))
+	versions := make([]string, 0, len(files))
+	for _, file := range files {
+		mig, err := m.loadMigration(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		checksums[mig.version] = mig.checksum
+		versions = append(versions, mig.version)
+	}
+	return checksums, versions, nil
+}
+
+// DBCompareResult lists the differences between the migrations applied to
+// two databases, each in migration order, followed by versions without a
+// migration file sorted by name. As with IsApplied, a migration recorded as
+// skipped is not applied, so one skipped on a database counts as drift if it
+// ran on the other.
+type DBCompareResult struct {
+	// OnlyA lists versions applied only to the first database.
+	OnlyA []string
+	// OnlyB lists versions applied only to the second database.
+	OnlyB []string
+	// Common lists versions applied to both.
+	Common []string
+}
+
+// CompareDatabases compares the migrations tables of a and b, e.g. staging
+// and production, to detect drift between environments. Both are read like
+// StatusWith, in read-only transactions that never write.
+func (m *Migrator) CompareDatabases(ctx context.Context, a, b *sql.DB) (DBCompareResult, error) {
+	inA, err := m.appliedIn(ctx, a)
+	if err != nil {
+		return DBCompareResult{}, err
+	}
+	inB, err := m.appliedIn(ctx, b)
+	if err != nil {
+		return DBCompareResult{}, err
+	}
+
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return DBCompareResult{}, fmt.Errorf("failed to get migration files: %w", err)
+	}
+	seen := make(map[string]bool, len(files))
+	var versions, unknown []string
+	for _, file := range files {
+		seen[versionOf(file)] = true
+		versions = append(versions, versionOf(file))
+	}
+	for _, applied := range []map[string]MigrationStatus{inA, inB} {
+		for version, status := range applied {
+			if status.ran() && !seen[version] {
+				seen[version] = true
+				unknown = append(unknown, version)
+			}
+		}
+	}
+	sort.Strings(unknown)
+
+	var result DBCompareResult
+	for _, version := range append(versions, unknown...) {
+		okA := inA[version].ran()
+		okB := inB[version].ran()
+		switch {
+		case okA && okB:
+			result.Common = append(result.Common, version)
+		case okA:
+			result.OnlyA = append(result.OnlyA, version)
+		case okB:
+			result.OnlyB = append(result.OnlyB, version)
+		}
+	}
+	return result, nil
+}
+
+// appliedIn reads the applied migrations of db in a read-only transaction.
+func (m *Migrator) appliedIn(ctx context.Context, db *sql.DB) (map[string]MigrationStatus, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	applied, err := m.getAppliedRecords(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	return applied, nil
+}
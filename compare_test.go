@@ -0,0 +1,34 @@
+package migrator
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCompareTo(t *testing.T) {
+	here := fstest.MapFS{
+		"001_same.sql":     &fstest.MapFile{Data: []byte("CREATE TABLE same (id INT);")},
+		"002_removed.sql":  &fstest.MapFile{Data: []byte("CREATE TABLE removed (id INT);")},
+		"003_modified.sql": &fstest.MapFile{Data: []byte("CREATE TABLE modified (id INT);")},
+	}
+	other := fstest.MapFS{
+		"001_same.sql":     &fstest.MapFile{Data: []byte("CREATE TABLE same (id INT);")},
+		"003_modified.sql": &fstest.MapFile{Data: []byte("CREATE TABLE modified (id BIGINT);")},
+		"004_added.sql":    &fstest.MapFile{Data: []byte("CREATE TABLE added (id INT);")},
+	}
+
+	result, err := newFileMigrator(t, here).CompareTo(other)
+	if err != nil {
+		t.Fatalf("failed to compare migrations: %v", err)
+	}
+	if !slices.Equal(result.OnlyHere, []string{"002_removed"}) {
+		t.Errorf("expected OnlyHere [002_removed], got %v", result.OnlyHere)
+	}
+	if !slices.Equal(result.OnlyInOther, []string{"004_added"}) {
+		t.Errorf("expected OnlyInOther [004_added], got %v", result.OnlyInOther)
+	}
+	if !slices.Equal(result.Changed, []string{"003_modified"}) {
+		t.Errorf("expected Changed [003_modified], got %v", result.Changed)
+	}
+}
@@ -0,0 +1,113 @@
+package migrator
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// ErrNotConfirmed is returned by Run when the WithConfirm function declines
+// the pending migrations. Nothing is applied.
+var ErrNotConfirmed = errors.New("migrator: migrations not confirmed")
+
+// ConfirmFunc asks whether to go ahead with an operation described by
+// prompt, such as applying pending migrations.
+type ConfirmFunc func(ctx context.Context, prompt string) (bool, error)
+
+// PromptConfirm returns a ConfirmFunc for command-line tools that writes the
+// prompt to out and reads the answer from in, e.g. os.Stdin and os.Stdout.
+// Only "y" or "yes", in any case, confirm; any other answer or the end of
+// input declines.
+func PromptConfirm(in io.Reader, out io.Writer) ConfirmFunc {
+	r := bufio.NewReader(in)
+	return func(ctx context.Context, prompt string) (bool, error) {
+		if _, err := fmt.Fprintf(out, "%s [y/N]: ", prompt); err != nil {
+			return false, err
+		}
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+}
+
+// confirmPending asks the WithConfirm function whether to apply the
+// migrations that a run would apply, reading the migrations table without
+// locking it. It returns the confirmed versions, which the run checks again
+// once it holds its locks.
+func (m *Migrator) confirmPending(ctx context.Context) ([]string, error) {
+	files, state, err := m.readState(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[string]bool, len(state))
+	for version := range state {
+		applied[version] = true
+	}
+
+	pending, err := m.pendingVersions(files, applied)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return pending, nil
+	}
+
+	ok, err := m.cfg.confirm(ctx, fmt.Sprintf("Apply %d migrations (%s)?", len(pending), strings.Join(pending, ", ")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm migrations: %w", err)
+	}
+	if !ok {
+		return nil, ErrNotConfirmed
+	}
+	return pending, nil
+}
+
+// checkConfirmed returns ErrNotConfirmed when the migrations a run would
+// apply differ from the confirmed ones, e.g. because another migrator applied
+// some while the prompt was open.
+func (m *Migrator) checkConfirmed(files []string, applied map[string]bool, confirmed []string) error {
+	pending, err := m.pendingVersions(files, applied)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(pending, confirmed) {
+		return fmt.Errorf("%w: pending migrations changed to %s while waiting for confirmation", ErrNotConfirmed, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// pendingVersions returns the versions a run would apply, up to and
+// including the first migration with a pause directive. It loads the pending
+// files to apply the same environment and type filters as the run.
+func (m *Migrator) pendingVersions(files []string, applied map[string]bool) ([]string, error) {
+	pending := []string{}
+	for _, file := range files {
+		version := versionOf(file)
+		if applied[version] || m.beforeSince(file) || m.skipsPattern(version) {
+			continue
+		}
+		mig, err := m.loadMigration(file)
+		if err != nil {
+			return nil, err
+		}
+		if !mig.directives.runsIn(m.cfg.environment) || !mig.directives.runsAs(m.cfg.runType) {
+			continue
+		}
+		pending = append(pending, version)
+		if mig.directives.pause {
+			break
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,50 @@
+package migrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPromptConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{input: "y\n", want: true},
+		{input: "YES\n", want: true},
+		{input: " y \r\n", want: true},
+		{input: "n\n", want: false},
+		{input: "\n", want: false},
+		{input: "yep\n", want: false},
+		{input: "", want: false},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		confirm := PromptConfirm(strings.NewReader(tt.input), &out)
+		got, err := confirm(context.Background(), "Apply 1 migrations (001_a)?")
+		if err != nil {
+			t.Fatalf("confirm(%q) failed: %v", tt.input, err)
+		}
+		if got != tt.want {
+			t.Fatalf("confirm(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if out.String() != "Apply 1 migrations (001_a)? [y/N]: " {
+			t.Fatalf("unexpected prompt %q", out.String())
+		}
+	}
+}
+
+func TestPromptConfirmReadsOneAnswerPerPrompt(t *testing.T) {
+	var out strings.Builder
+	confirm := PromptConfirm(strings.NewReader("n\ny\n"), &out)
+	for _, want := range []bool{false, true} {
+		got, err := confirm(context.Background(), "Continue?")
+		if err != nil {
+			t.Fatalf("confirm failed: %v", err)
+		}
+		if got != want {
+			t.Fatalf("confirm() = %v, want %v", got, want)
+		}
+	}
+}
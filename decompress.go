@@ -0,0 +1,102 @@
+package migrator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decompressor wraps the compressed content of a migration file in a reader
+// of its SQL, e.g. gzip.NewReader.
+type Decompressor func(r io.Reader) (io.Reader, error)
+
+// decompressorFor returns the decompressor registered for a file name ending
+// in ".sql" followed by its extension, or nil for plain files.
+func (m *Migrator) decompressorFor(name string) (ext string, d Decompressor) {
+	for ext, d := range m.cfg.decompressors {
+		if strings.HasSuffix(name, ".sql"+ext) {
+			return ext, d
+		}
+	}
+	return "", nil
+}
+
+// isMigrationFile reports whether name is a plain or registered compressed
+// SQL file.
+func (m *Migrator) isMigrationFile(name string) bool {
+	if strings.HasSuffix(name, ".sql") {
+		return true
+	}
+	_, d := m.decompressorFor(name)
+	return d != nil
+}
+
+// openMigration opens a migration file, decompressing it when its extension
+// has a registered decompressor. Errors reading it are *fileReadError.
+func (m *Migrator) openMigration(file string) (io.ReadCloser, error) {
+	f, err := m.migrations.Open(file)
+	if err != nil {
+		return nil, &fileReadError{err: err}
+	}
+	ext, d := m.decompressorFor(file)
+	if d == nil {
+		return fileReader{f}, nil
+	}
+
+	r, err := d(f)
+	if err != nil {
+		f.Close()
+		return nil, &fileReadError{err: fmt.Errorf("failed to decompress %s with the %s decompressor: %w", file, ext, err)}
+	}
+	return fileReader{&decompressedFile{Reader: r, file: f}}, nil
+}
+
+// fileReadError is an error reading a migration file. It keeps a truncated
+// compressed file's io.ErrUnexpectedEOF from being taken for a lost
+// connection by isConnectionError.
+type fileReadError struct {
+	err error
+}
+
+func (e *fileReadError) Error() string { return e.err.Error() }
+func (e *fileReadError) Unwrap() error { return e.err }
+
+// fileReader wraps the errors of reading a migration file, other than the
+// io.EOF that ends it, in *fileReadError.
+type fileReader struct {
+	io.ReadCloser
+}
+
+func (r fileReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = &fileReadError{err: err}
+	}
+	return n, err
+}
+
+// readMigration reads a whole migration file, decompressing it if needed.
+func (m *Migrator) readMigration(file string) ([]byte, error) {
+	f, err := m.openMigration(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// decompressedFile closes both the decompressing reader, if it is a Closer,
+// and the underlying file.
+type decompressedFile struct {
+	io.Reader
+	file io.Closer
+}
+
+func (f *decompressedFile) Close() error {
+	var err error
+	if c, ok := f.Reader.(io.Closer); ok {
+		err = c.Close()
+	}
+	return errors.Join(err, f.file.Close())
+}
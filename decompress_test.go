@@ -0,0 +1,85 @@
+package migrator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+	"testing/fstest"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestDecompressor(t *testing.T) {
+	const sql = "CREATE TABLE b (id INT);"
+	migrations := fstest.MapFS{
+		"001_a.sql":    &fstest.MapFile{Data: []byte("CREATE TABLE a (id INT);")},
+		"002_b.sql.gz": &fstest.MapFile{Data: gzipped(t, sql)},
+		"003_c.sql.xz": &fstest.MapFile{Data: []byte("not registered")},
+	}
+	m := newFileMigrator(t, migrations, WithDecompressor(".gz", func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	}))
+
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		t.Fatalf("failed to get migration files: %v", err)
+	}
+	if len(files) != 2 || files[1] != "002_b.sql.gz" {
+		t.Fatalf("expected the plain and gzip files, got %v", files)
+	}
+
+	mig, err := m.loadMigration("002_b.sql.gz")
+	if err != nil {
+		t.Fatalf("failed to load migration: %v", err)
+	}
+	if mig.version != "002_b" {
+		t.Fatalf("expected version 002_b, got %s", mig.version)
+	}
+	if mig.content != sql {
+		t.Fatalf("expected decompressed content %q, got %q", sql, mig.content)
+	}
+	if mig.checksum != defaultConfig().checksum([]byte(sql)) {
+		t.Fatal("expected checksum of the decompressed SQL")
+	}
+}
+
+func TestDecompressorDuplicateVersion(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_a.sql":    &fstest.MapFile{Data: []byte("CREATE TABLE a (id INT);")},
+		"001_a.sql.gz": &fstest.MapFile{Data: gzipped(t, "CREATE TABLE a (id INT);")},
+	}
+	m := newFileMigrator(t, migrations, WithDecompressor("gz", func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	}))
+	if _, err := m.getMigrationFiles(); err == nil {
+		t.Fatal("expected error for a version present both plain and compressed")
+	}
+}
+
+func TestTruncatedCompressedFileIsNotAConnectionError(t *testing.T) {
+	data := gzipped(t, "CREATE TABLE b (id INT);")
+	m := newFileMigrator(t, fstest.MapFS{
+		"001_b.sql.gz": &fstest.MapFile{Data: data[:len(data)-8]},
+	}, WithDecompressor(".gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }))
+
+	_, err := m.readMigration("001_b.sql.gz")
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated file, got %v", err)
+	}
+	if isConnectionError(err) {
+		t.Fatalf("expected a truncated file not to be retried as a connection error")
+	}
+}
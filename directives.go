@@ -0,0 +1,175 @@
+package migrator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const directivePrefix = "-- migrator:"
+
+// directives holds the "-- migrator:" comments declared in a migration file.
+type directives struct {
+	// envs lists the environments the migration runs in. Empty means all.
+	envs []string
+	// skipIf is a SQL boolean predicate; when it is true the migration is
+	// recorded as skipped without running.
+	skipIf string
+	// backups lists the tables passed to the backup handler before the
+	// migration runs.
+	backups []string
+	// deferConstraints defers deferrable constraint checks to the end of
+	// the migration.
+	deferConstraints bool
+	// release names the release the migration ships in.
+	release string
+	// pause stops Run after the migration, committing the work so far.
+	pause bool
+	// appLocks lists application advisory locks taken before the migration
+	// runs and held until the migrations commit.
+	appLocks []int64
+	// data marks a data migration, such as a backfill, as opposed to a
+	// schema migration.
+	data bool
+}
+
+// parseDirectives reads the directives from a migration file. Each directive
+// is a line comment of the form "-- migrator:<name> <args>".
+func parseDirectives(file, content string) (directives, error) {
+	return parseDirectivesFrom(file, strings.NewReader(content))
+}
+
+// parseDirectivesFrom reads the directives from r until EOF. Only the first
+// few bytes of long lines are inspected, so memory stays bounded for files
+// with very long statements.
+func parseDirectivesFrom(file string, r io.Reader) (directives, error) {
+	var d directives
+	typed := false
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := readDirectiveLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return directives{}, err
+		}
+
+		text = strings.TrimSpace(text)
+		if !strings.HasPrefix(text, directivePrefix) {
+			continue
+		}
+
+		name, args, _ := strings.Cut(strings.TrimPrefix(text, directivePrefix), " ")
+		args = strings.TrimSpace(args)
+		switch name {
+		case "env":
+			for _, env := range strings.Split(args, ",") {
+				if env = strings.TrimSpace(env); env != "" {
+					d.envs = append(d.envs, env)
+				}
+			}
+			if len(d.envs) == 0 {
+				return directives{}, fmt.Errorf("%s:%d: migrator:env requires at least one environment", file, line)
+			}
+		case "skip-if":
+			if args == "" {
+				return directives{}, fmt.Errorf("%s:%d: migrator:skip-if requires a predicate", file, line)
+			}
+			if d.skipIf != "" {
+				return directives{}, fmt.Errorf("%s:%d: duplicate migrator:skip-if directive", file, line)
+			}
+			d.skipIf = args
+		case "release":
+			if args == "" {
+				return directives{}, fmt.Errorf("%s:%d: migrator:release requires a name", file, line)
+			}
+			if d.release != "" {
+				return directives{}, fmt.Errorf("%s:%d: duplicate migrator:release directive", file, line)
+			}
+			d.release = args
+		case "defer-constraints":
+			if args != "" {
+				return directives{}, fmt.Errorf("%s:%d: migrator:defer-constraints takes no arguments", file, line)
+			}
+			d.deferConstraints = true
+		case "type":
+			if typed {
+				return directives{}, fmt.Errorf("%s:%d: duplicate migrator:type directive", file, line)
+			}
+			typed = true
+			switch args {
+			case "schema":
+				d.data = false
+			case "data":
+				d.data = true
+			default:
+				return directives{}, fmt.Errorf("%s:%d: migrator:type must be schema or data, got %q", file, line, args)
+			}
+		case "app-lock":
+			id, err := strconv.ParseInt(args, 10, 64)
+			if err != nil {
+				return directives{}, fmt.Errorf("%s:%d: migrator:app-lock requires an integer lock ID", file, line)
+			}
+			d.appLocks = append(d.appLocks, id)
+		case "pause":
+			if args != "" {
+				return directives{}, fmt.Errorf("%s:%d: migrator:pause takes no arguments", file, line)
+			}
+			d.pause = true
+		case "backup":
+			before := len(d.backups)
+			for _, table := range strings.Split(args, ",") {
+				if table = strings.TrimSpace(table); table != "" {
+					d.backups = append(d.backups, table)
+				}
+			}
+			if len(d.backups) == before {
+				return directives{}, fmt.Errorf("%s:%d: migrator:backup requires at least one table", file, line)
+			}
+		default:
+			return directives{}, fmt.Errorf("%s:%d: unknown directive %q", file, line, name)
+		}
+	}
+	return d, nil
+}
+
+// readDirectiveLine returns the next line from r. Lines that cannot be
+// directives are consumed without being buffered in full.
+func readDirectiveLine(r *bufio.Reader) (string, error) {
+	fragment, isPrefix, err := r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	line := string(fragment)
+	keep := strings.HasPrefix(strings.TrimSpace(line), "--")
+	for isPrefix {
+		if fragment, isPrefix, err = r.ReadLine(); err != nil {
+			return line, nil
+		}
+		if keep {
+			line += string(fragment)
+		}
+	}
+	return line, nil
+}
+
+// runsIn reports whether the migration runs in the given environment.
+func (d directives) runsIn(env string) bool {
+	return len(d.envs) == 0 || slices.Contains(d.envs, env)
+}
+
+// runsAs reports whether the migration is of a kind that t applies.
+func (d directives) runsAs(t RunType) bool {
+	switch t {
+	case RunSchema:
+		return !d.data
+	case RunData:
+		return d.data
+	default:
+		return true
+	}
+}
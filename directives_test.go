@@ -0,0 +1,27 @@
+package migrator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDirectivesRejectsDuplicates(t *testing.T) {
+	for _, content := range []string{
+		"-- migrator:skip-if true\n-- migrator:skip-if false\n",
+		"-- migrator:release 2024.1\n-- migrator:release 2024.2\n",
+		"-- migrator:type data\n-- migrator:type schema\n",
+	} {
+		_, err := parseDirectives("001_a.sql", content)
+		if err == nil || !strings.Contains(err.Error(), "001_a.sql:2: duplicate") {
+			t.Fatalf("expected a duplicate directive error on line 2 for %q, got %v", content, err)
+		}
+	}
+
+	d, err := parseDirectives("001_a.sql", "-- migrator:type data\nUPDATE users SET active = true;\n")
+	if err != nil {
+		t.Fatalf("failed to parse directives: %v", err)
+	}
+	if !d.data {
+		t.Fatal("expected a data migration")
+	}
+}
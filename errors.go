@@ -0,0 +1,150 @@
+package migrator
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrPendingMigrations is returned by EnsureMigrated when migrations have not
+// been applied yet. Use errors.As with *PendingMigrationsError to get the
+// pending versions.
+var ErrPendingMigrations = errors.New("migrator: pending migrations")
+
+// ErrTableLocked is returned by Run when the migrations table could not be
+// locked within the timeout set by WithTableLockTimeout, because another
+// session holds a conflicting lock on it. It is distinct from the advisory
+// lock error returned when another migration is in progress.
+var ErrTableLocked = errors.New("migrator: migrations table is locked")
+
+// ErrChecksumMismatch is returned by Run with WithVerifyChecksums when an
+// applied migration file no longer matches the checksum recorded for it, and
+// whatever the options when a file renamed since it was applied does.
+var ErrChecksumMismatch = errors.New("migrator: checksum mismatch")
+
+// PendingMigrationsError lists the migrations that have not been applied.
+type PendingMigrationsError struct {
+	Versions []string
+}
+
+func (e *PendingMigrationsError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPendingMigrations, strings.Join(e.Versions, ", "))
+}
+
+// Is reports whether target is ErrPendingMigrations.
+func (e *PendingMigrationsError) Is(target error) bool {
+	return target == ErrPendingMigrations
+}
+
+// ErrPaused is returned by Run when a migration with a pause directive was
+// applied. The migrations up to and including it are committed, and the next
+// Run continues after it. Use errors.As with *PausedError to get the version.
+var ErrPaused = errors.New("migrator: paused")
+
+// PausedError names the migration whose pause directive stopped the run.
+type PausedError struct {
+	Version string
+}
+
+func (e *PausedError) Error() string {
+	return fmt.Sprintf("%s after %s", ErrPaused, e.Version)
+}
+
+// Is reports whether target is ErrPaused.
+func (e *PausedError) Is(target error) bool {
+	return target == ErrPaused
+}
+
+// Phase names a stage of a migration run, for DeadlineError.
+type Phase string
+
+const (
+	// PhaseConnect is acquiring a connection from the pool.
+	PhaseConnect Phase = "acquiring a connection"
+	// PhaseLock is taking the advisory lock and locking the migrations
+	// table, including any wait for another migration to finish.
+	PhaseLock Phase = "waiting for the lock"
+	// PhaseMigrate is applying the migrations and committing.
+	PhaseMigrate Phase = "running migrations"
+)
+
+// DeadlineError is returned when the context deadline passed to Run or
+// another locking method expires, naming the phase that used up the budget.
+// errors.Is(err, context.DeadlineExceeded) holds for it.
+type DeadlineError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *DeadlineError) Error() string {
+	return fmt.Sprintf("migrator: deadline exceeded while %s: %v", e.Phase, e.Err)
+}
+
+// Unwrap returns both context.DeadlineExceeded and the underlying error,
+// since drivers often report a cancelled query without wrapping ctx.Err().
+func (e *DeadlineError) Unwrap() []error {
+	return []error{context.DeadlineExceeded, e.Err}
+}
+
+// sqlState returns the SQLSTATE code of a driver error, or "" if err does not
+// carry one. Both lib/pq and pgx errors implement SQLState.
+func sqlState(err error) string {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+	return ""
+}
+
+// errorPosition returns the 1-based character position of a driver error
+// within the failing query, or 0 if err does not carry one. Drivers expose it
+// as a Position field rather than a method: a string in lib/pq, an integer in
+// pgx.
+func errorPosition(err error) int {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		if v.Kind() == reflect.Pointer {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		f := v.FieldByName("Position")
+		switch f.Kind() {
+		case reflect.String:
+			if pos, err := strconv.Atoi(f.String()); err == nil {
+				return pos
+			}
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			return int(f.Int())
+		}
+	}
+	return 0
+}
+
+// isConnectionError reports whether err means the connection to the server
+// was lost, as opposed to a problem with the SQL, so that retrying on a new
+// connection may succeed.
+func isConnectionError(err error) bool {
+	var fileErr *fileReadError
+	if errors.As(err, &fileErr) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	state := sqlState(err)
+	// Class 08 is connection exceptions; 57P01 to 57P03 are the server
+	// shutting down or not yet accepting connections, e.g. during failover.
+	return strings.HasPrefix(state, "08") || state == "57P01" || state == "57P02" || state == "57P03"
+}
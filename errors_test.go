@@ -0,0 +1,56 @@
+package migrator
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+type stateError string
+
+func (e stateError) Error() string    { return "sql error " + string(e) }
+func (e stateError) SQLState() string { return string(e) }
+
+func TestIsConnectionError(t *testing.T) {
+	retryable := []error{
+		driver.ErrBadConn,
+		fmt.Errorf("failed to apply migration 001: %w", stateError("57P01")),
+		stateError("08006"),
+	}
+	for _, err := range retryable {
+		if !isConnectionError(err) {
+			t.Errorf("expected %v to be a connection error", err)
+		}
+	}
+
+	permanent := []error{
+		stateError("42601"), // syntax_error
+		stateError("23505"), // unique_violation
+		errors.New("another migration is in progress"),
+		fmt.Errorf("failed to read migration file 001.sql.gz: %w", &fileReadError{err: io.ErrUnexpectedEOF}),
+	}
+	for _, err := range permanent {
+		if isConnectionError(err) {
+			t.Errorf("expected %v not to be a connection error", err)
+		}
+	}
+}
+
+func TestDeadlineError(t *testing.T) {
+	cause := stateError("57014") // query_canceled
+	err := fmt.Errorf("run failed: %w", &DeadlineError{Phase: PhaseLock, Err: cause})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v to match context.DeadlineExceeded", err)
+	}
+	if sqlState(err) != "57014" {
+		t.Fatalf("expected the driver error to be unwrapped, got SQLSTATE %q", sqlState(err))
+	}
+	if want := "migrator: deadline exceeded while waiting for the lock: sql error 57014"; !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected error to contain %q, got %q", want, err.Error())
+	}
+}
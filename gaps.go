@@ -0,0 +1,73 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxGapSpan bounds the version range DetectGaps scans, so non-sequential
+// versions such as timestamps fail instead of producing millions of gaps.
+const maxGapSpan = 1_000_000
+
+// DetectGaps returns the version numbers missing between the lowest and
+// highest version known from the migration files and the migrations table,
+// zero-padded like NextVersion, e.g. ["002"] when 001 and 003 exist but 002
+// is neither a file nor applied. A gap usually means a migration was lost.
+// It requires sequentially numbered Lexical versions.
+func (m *Migrator) DetectGaps(ctx context.Context) ([]string, error) {
+	if m.cfg.scheme != Lexical {
+		return nil, errors.New("DetectGaps requires sequential versions with the Lexical scheme")
+	}
+
+	files, applied, err := m.readState(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(files)+len(applied))
+	for _, file := range files {
+		versions = append(versions, versionOf(file))
+	}
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	return versionGaps(versions, m.cfg.versionWidth)
+}
+
+// versionGaps returns the numbers missing from the digit prefixes of
+// versions, formatted with the given zero-padded width. Versions without a
+// digit prefix are ignored.
+func versionGaps(versions []string, width int) ([]string, error) {
+	known := make(map[uint64]bool, len(versions))
+	var lowest, highest uint64
+	for _, version := range versions {
+		n, ok, err := Lexical.leadingNumber(version)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if len(known) == 0 || n < lowest {
+			lowest = n
+		}
+		if n > highest {
+			highest = n
+		}
+		known[n] = true
+	}
+	if len(known) == 0 {
+		return nil, nil
+	}
+	if highest-lowest > maxGapSpan {
+		return nil, fmt.Errorf("versions from %d to %d are not sequential", lowest, highest)
+	}
+
+	var gaps []string
+	for n := lowest + 1; n < highest; n++ {
+		if !known[n] {
+			gaps = append(gaps, fmt.Sprintf("%0*d", width, n))
+		}
+	}
+	return gaps, nil
+}
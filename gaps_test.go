@@ -0,0 +1,33 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersionGaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "none", versions: nil},
+		{name: "contiguous", versions: []string{"001_a", "002_b", "003_c"}},
+		{name: "missing middle", versions: []string{"001_a", "003_c"}, want: []string{"002"}},
+		{name: "several", versions: []string{"005_e", "001_a", "004_d"}, want: []string{"002", "003"}},
+		{name: "duplicates and undigited", versions: []string{"001_a", "001_a", "readme", "003_c"}, want: []string{"002"}},
+		{name: "timestamps", versions: []string{"20240101000000_a", "20240301000000_b"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := versionGaps(tt.versions, 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("versionGaps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("versionGaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GenerateSQL writes the pending migrations to w as a single SQL script
+// instead of executing them, for review and application through a separate
+// change-management pipeline. The script creates the migrations table if
+// needed, runs each migration in order and records it, all between BEGIN and
+// COMMIT. Like Run, it records the new version of files renamed since they
+// were applied, tags statements with WithStatementTag, leaves out migrations
+// matching WithSkipPattern and ends with the WithPostMigrationSQL statements.
+//
+// The applied migrations are read from the database unless WithAssumeFresh
+// is set, in which case every migration is pending and no connection is
+// made. Migrations with a skip-if directive cannot be generated, because the
+// predicate is only known at run time, and neither can migrations with a
+// backup directive, migrations recorded by a custom Tracker, or migrations
+// using WithMigrationParams.
+func (m *Migrator) GenerateSQL(ctx context.Context, w io.Writer) error {
+	if m.cfg.tracker != nil {
+		return errors.New("cannot generate SQL with a custom tracker")
+	}
+	if len(m.cfg.params) > 0 {
+		return errors.New("cannot generate SQL with migration parameters")
+	}
+
+	var (
+		files   []string
+		applied map[string]MigrationStatus
+		renamed map[string]string
+		err     error
+	)
+	if m.cfg.assumeFresh {
+		files, err = m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+	} else {
+		files, applied, renamed, err = m.readStateWithRenames(ctx, m.db)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "BEGIN;\n%s%s\n", m.sessionSettingsSQL(), dedentDDL(migrationsTableDDL(m.table, m.cfg.timestampTZ))); err != nil {
+		return fmt.Errorf("failed to write SQL: %w", err)
+	}
+
+	for _, file := range files {
+		version := versionOf(file)
+		if old, ok := renamed[version]; ok {
+			if _, err := fmt.Fprintf(w, "\n-- %s (renamed from %s)\nUPDATE %s SET version = %s WHERE version = %s;\n",
+				file, old, m.table, quoteLiteral(version), quoteLiteral(old)); err != nil {
+				return fmt.Errorf("failed to write SQL: %w", err)
+			}
+		}
+	}
+
+	written := 0
+	for _, file := range files {
+		version := versionOf(file)
+		if _, ok := applied[version]; ok {
+			continue
+		}
+		if m.skipsPattern(version) {
+			continue
+		}
+
+		mig, err := m.loadMigration(file)
+		if err != nil {
+			return err
+		}
+		if !mig.directives.runsIn(m.cfg.environment) {
+			if err := m.writeRecordSQL(w, mig, true); err != nil {
+				return fmt.Errorf("failed to write SQL for migration %s: %w", version, err)
+			}
+			continue
+		}
+		if !mig.directives.runsAs(m.cfg.runType) {
+			continue
+		}
+		if mig.directives.skipIf != "" {
+			return fmt.Errorf("migration %s has a skip-if directive, which cannot be generated", version)
+		}
+		if len(mig.directives.backups) > 0 {
+			return fmt.Errorf("migration %s has a backup directive, which cannot be generated", version)
+		}
+		if err := m.writeMigrationSQL(w, mig); err != nil {
+			return fmt.Errorf("failed to write SQL for migration %s: %w", version, err)
+		}
+		written++
+		if mig.directives.pause {
+			break
+		}
+	}
+
+	// Like Run, the post-migration statements follow only when a migration
+	// was applied rather than recorded as skipped.
+	if written > 0 && len(m.cfg.postMigrationSQL) > 0 {
+		if _, err := io.WriteString(w, "\n-- post-migration\n"); err != nil {
+			return fmt.Errorf("failed to write SQL: %w", err)
+		}
+		for _, stmt := range m.cfg.postMigrationSQL {
+			stmt = strings.TrimSpace(stmt)
+			if !strings.HasSuffix(stmt, ";") {
+				stmt += ";"
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", stmt); err != nil {
+				return fmt.Errorf("failed to write SQL: %w", err)
+			}
+		}
+	}
+
+	if _, err := io.WriteString(w, "\nCOMMIT;\n"); err != nil {
+		return fmt.Errorf("failed to write SQL: %w", err)
+	}
+	return nil
+}
+
+// writeMigrationSQL writes the body of mig followed by the insert recording it.
+func (m *Migrator) writeMigrationSQL(w io.Writer, mig *migration) error {
+	if _, err := fmt.Fprintf(w, "\n-- %s\n", mig.file); err != nil {
+		return err
+	}
+
+	content := mig.content
+	if mig.streamed {
+		data, err := m.readMigration(mig.file)
+		if err != nil {
+			return err
+		}
+		content = string(data)
+	}
+
+	content = strings.TrimSpace(content)
+	if tag := m.statementTag(mig); tag != "" && m.cfg.splitStatements {
+		// Run tags each statement it executes separately.
+		stmts, err := splitStatements(mig.file, content)
+		if err != nil {
+			return err
+		}
+		tagged := make([]string, len(stmts))
+		for i, stmt := range stmts {
+			tagged[i] = tag + strings.TrimSpace(stmt.sql) + ";"
+		}
+		content = strings.Join(tagged, "\n")
+	} else {
+		content = m.statementTag(mig) + content
+	}
+	if !strings.HasSuffix(content, ";") {
+		content += "\n;"
+	}
+	if mig.directives.deferConstraints {
+		content = "SET CONSTRAINTS ALL DEFERRED;\n" + content + "\nSET CONSTRAINTS ALL IMMEDIATE;\n" + restoreDeferredSQL + ";"
+	}
+	for i := len(mig.directives.appLocks) - 1; i >= 0; i-- {
+		content = fmt.Sprintf("SELECT pg_advisory_xact_lock(%d);\n", mig.directives.appLocks[i]) + content
+	}
+
+	if _, err := fmt.Fprintf(w, "%s\n", content); err != nil {
+		return err
+	}
+	return m.writeRecordSQL(w, mig, false)
+}
+
+// writeRecordSQL writes the insert recording mig, preceded by a comment
+// naming the file when it is recorded as skipped without its body.
+func (m *Migrator) writeRecordSQL(w io.Writer, mig *migration, skipped bool) error {
+	if skipped {
+		if _, err := fmt.Fprintf(w, "\n-- %s (skipped)\n", mig.file); err != nil {
+			return err
+		}
+	}
+	appliedBy, release := "NULL", "NULL"
+	if m.cfg.appliedBy != "" {
+		appliedBy = quoteLiteral(m.cfg.appliedBy)
+	}
+	if mig.directives.release != "" {
+		release = quoteLiteral(mig.directives.release)
+	}
+	_, err := fmt.Fprintf(w, "INSERT INTO %s (version, applied_by, checksum, skipped, release, path, server_version) VALUES (%s, %s, %s, %t, %s, %s, current_setting('server_version'));\n",
+		m.table, quoteLiteral(mig.version), appliedBy, quoteLiteral(mig.checksum), skipped, release, quoteLiteral(mig.file))
+	return err
+}
+
+// dedentDDL strips the indentation of a multi-line statement embedded in Go
+// source.
+func dedentDDL(ddl string) string {
+	lines := strings.Split(strings.TrimSpace(ddl), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, "\t\t")
+	}
+	return strings.Join(lines, "\n")
+}
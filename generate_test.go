@@ -0,0 +1,97 @@
+package migrator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGenerateSQL(t *testing.T) {
+	m := newFileMigrator(t, testMigrationsFS(t), WithAssumeFresh(true), WithAppliedBy("ci"))
+
+	var b strings.Builder
+	if err := m.GenerateSQL(context.Background(), &b); err != nil {
+		t.Fatalf("failed to generate SQL: %v", err)
+	}
+	script := b.String()
+
+	if !strings.HasPrefix(script, "BEGIN;\n") || !strings.HasSuffix(script, "COMMIT;\n") {
+		t.Fatalf("expected script wrapped in BEGIN/COMMIT, got:\n%s", script)
+	}
+
+	want := []string{
+		`CREATE TABLE IF NOT EXISTS "schema_migrations"`,
+		"CREATE TABLE test_table",
+		`INSERT INTO "schema_migrations" (version, applied_by, checksum, skipped, release, path, server_version) VALUES ('001_create_test_table', 'ci', '`,
+		"ADD COLUMN test_column TEXT;",
+		`INSERT INTO "schema_migrations" (version, applied_by, checksum, skipped, release, path, server_version) VALUES ('002_add_test_column', 'ci', '`,
+	}
+	pos := 0
+	for _, s := range want {
+		i := strings.Index(script[pos:], s)
+		if i < 0 {
+			t.Fatalf("expected %q after offset %d in:\n%s", s, pos, script)
+		}
+		pos += i + len(s)
+	}
+}
+
+func TestGenerateSQLStopsAtPause(t *testing.T) {
+	m := newFileMigrator(t, fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("-- migrator:pause\nCREATE TABLE users (id INT);")},
+		"002_create_posts.sql": &fstest.MapFile{Data: []byte("CREATE TABLE posts (id INT);")},
+	}, WithAssumeFresh(true))
+
+	var b strings.Builder
+	if err := m.GenerateSQL(context.Background(), &b); err != nil {
+		t.Fatalf("failed to generate SQL: %v", err)
+	}
+	if !strings.Contains(b.String(), "CREATE TABLE users") || strings.Contains(b.String(), "CREATE TABLE posts") {
+		t.Fatalf("expected script to stop after the paused migration, got:\n%s", b.String())
+	}
+}
+
+func TestGenerateSQLMatchesRunOptions(t *testing.T) {
+	m := newFileMigrator(t, fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("CREATE TABLE users (id INT);")},
+		"002_hotfix_wip.sql":   &fstest.MapFile{Data: []byte("CREATE TABLE wip (id INT);")},
+	}, WithAssumeFresh(true),
+		WithSkipPattern("*_wip"),
+		WithPostMigrationSQL([]string{"ANALYZE users"}))
+
+	var b strings.Builder
+	if err := m.GenerateSQL(context.Background(), &b); err != nil {
+		t.Fatalf("failed to generate SQL: %v", err)
+	}
+	script := b.String()
+	if strings.Contains(script, "CREATE TABLE wip") {
+		t.Fatalf("expected the skip pattern to leave out 002_hotfix_wip, got:\n%s", script)
+	}
+	users := strings.Index(script, "CREATE TABLE users")
+	analyze := strings.Index(script, "ANALYZE users;")
+	if users < 0 || analyze < users || analyze > strings.Index(script, "COMMIT;") {
+		t.Fatalf("expected the post-migration SQL after the migrations and before COMMIT, got:\n%s", script)
+	}
+}
+
+func TestGenerateSQLTagsStatements(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("CREATE TABLE users (id INT);\nCREATE INDEX ON users (id);")},
+	}
+	for _, split := range []bool{false, true} {
+		m := newFileMigrator(t, migrations, WithAssumeFresh(true), WithStatementTag("deploy"), WithStatementSplitting(split))
+
+		var b strings.Builder
+		if err := m.GenerateSQL(context.Background(), &b); err != nil {
+			t.Fatalf("failed to generate SQL: %v", err)
+		}
+		want := "/* deploy: 001_create_users */ CREATE TABLE users (id INT);"
+		if split {
+			want += "\n/* deploy: 001_create_users */ CREATE INDEX ON users (id);"
+		}
+		if !strings.Contains(b.String(), want) {
+			t.Fatalf("expected %q with splitting %v, got:\n%s", want, split, b.String())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package migrator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sqlIdentPattern matches an identifier as written in migration SQL: either
+// unquoted or double-quoted with "" escapes. sqlNamePattern adds an optional
+// schema. Lint and Objects both find object names with them.
+const (
+	sqlIdentPattern = `(?:"(?:[^"]|"")+"|[A-Za-z_][\w$]*)`
+	sqlNamePattern  = sqlIdentPattern + `(?:\.` + sqlIdentPattern + `)?`
+)
+
+var (
+	sqlIdentRegexp = regexp.MustCompile(sqlIdentPattern)
+	sqlNameRegexp  = regexp.MustCompile(sqlNamePattern)
+)
+
+// maxIdentifierLength is PostgreSQL's NAMEDATALEN - 1; longer identifiers
+// are silently truncated.
+const maxIdentifierLength = 63
+
+// quoteTableName validates a table name, optionally qualified with a schema
+// as "schema.table", and returns it as a quoted SQL identifier. Names are
+// folded to lower case as PostgreSQL does for unquoted identifiers.
+func quoteTableName(name string) (string, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("invalid table name %q: at most one schema qualifier is allowed", name)
+	}
+	for i, part := range parts {
+		if !identifierPattern.MatchString(part) {
+			return "", fmt.Errorf("invalid table name %q: identifiers must match %s", name, identifierPattern)
+		}
+		parts[i] = quoteIdent(strings.ToLower(part))
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// quoteIdent quotes a SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// unqualifiedName returns the table part of a possibly schema-qualified name.
+func unqualifiedName(name string) string {
+	return strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+}
+
+// quoteLiteral quotes s as a SQL string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
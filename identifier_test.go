@@ -0,0 +1,35 @@
+package migrator
+
+import "testing"
+
+func TestQuoteTableName(t *testing.T) {
+	valid := map[string]string{
+		"schema_migrations":        `"schema_migrations"`,
+		"MyMigrations":             `"mymigrations"`,
+		"app.schema_migrations":    `"app"."schema_migrations"`,
+		"_private.migrations_2024": `"_private"."migrations_2024"`,
+	}
+	for name, expected := range valid {
+		quoted, err := quoteTableName(name)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", name, err)
+			continue
+		}
+		if quoted != expected {
+			t.Errorf("expected %q to quote as %s, got %s", name, expected, quoted)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"schema_migrations; DROP TABLE users",
+		`schema"migrations`,
+		"1migrations",
+		"a.b.c",
+		"app.",
+	} {
+		if _, err := quoteTableName(name); err == nil {
+			t.Errorf("expected error for %q, got nil", name)
+		}
+	}
+}
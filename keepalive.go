@@ -0,0 +1,55 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// keepalive pings the run's connection before a migration or statement when
+// the connection has been idle for interval since its last migration
+// statement, e.g. while a backup handler or pre-migration check ran, so
+// proxies do not drop it and a dead connection fails before the migration
+// rather than during it. Time spent running statements is not idle and never
+// triggers a ping.
+type keepalive struct {
+	interval time.Duration
+	logger   *slog.Logger
+	last     time.Time // end of the last statement or ping
+}
+
+// newKeepalive returns nil when WithKeepalive is not set.
+func (m *Migrator) newKeepalive() *keepalive {
+	if m.cfg.keepalive <= 0 {
+		return nil
+	}
+	return &keepalive{interval: m.cfg.keepalive, logger: m.cfg.logger, last: time.Now()}
+}
+
+// touch records activity on the connection. It does nothing on a nil
+// keepalive.
+func (k *keepalive) touch() {
+	if k != nil {
+		k.last = time.Now()
+	}
+}
+
+// ping runs a trivial query in tx when the connection has been idle for the
+// interval. It does nothing on a nil keepalive.
+func (k *keepalive) ping(ctx context.Context, tx *sql.Tx) error {
+	if k == nil {
+		return nil
+	}
+	idle := time.Since(k.last)
+	if idle < k.interval {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT 1`); err != nil {
+		return fmt.Errorf("failed to ping connection: %w", err)
+	}
+	k.logger.Debug("pinged connection", "idle", idle)
+	k.last = time.Now()
+	return nil
+}
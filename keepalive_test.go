@@ -0,0 +1,29 @@
+package migrator
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestKeepaliveNotDue(t *testing.T) {
+	var disabled *keepalive
+	if err := disabled.ping(context.Background(), nil); err != nil {
+		t.Fatalf("expected a nil keepalive to do nothing, got %v", err)
+	}
+
+	m := newFileMigrator(t, fstest.MapFS{}, WithKeepalive(time.Hour))
+	k := m.newKeepalive()
+	if k == nil {
+		t.Fatal("expected a keepalive with WithKeepalive set")
+	}
+	// A nil tx would panic if the ping ran.
+	if err := k.ping(context.Background(), nil); err != nil {
+		t.Fatalf("expected no ping before the interval, got %v", err)
+	}
+
+	if newFileMigrator(t, fstest.MapFS{}).newKeepalive() != nil {
+		t.Fatal("expected no keepalive by default")
+	}
+}
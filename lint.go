@@ -0,0 +1,101 @@
+package migrator
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// LintWarning is a likely mistake found by Lint.
+type LintWarning struct {
+	Version string
+	Line    int
+	Message string
+}
+
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s:%d: %s", w.Version, w.Line, w.Message)
+}
+
+var (
+	lintCreatePattern = regexp.MustCompile(`(?i)^CREATE\s+(?:UNLOGGED\s+)?(?:UNIQUE\s+)?(TABLE|INDEX)\s+(?:CONCURRENTLY\s+)?(` + sqlNamePattern + `)`)
+	lintDropPattern   = regexp.MustCompile(`(?i)^DROP\s+(?:TABLE|INDEX)\s+(?:CONCURRENTLY\s+)?(?:IF\s+EXISTS\s+)?(` + sqlNamePattern + `(?:\s*,\s*` + sqlNamePattern + `)*)`)
+	lintRenamePattern = regexp.MustCompile(`(?i)^ALTER\s+(?:TABLE|INDEX)\s+(?:IF\s+EXISTS\s+)?(` + sqlNamePattern + `)\s+RENAME\s+TO\s+(` + sqlNamePattern + `)`)
+)
+
+// Lint statically checks the migration set for likely copy-paste mistakes:
+// a CREATE TABLE or CREATE INDEX of a name that an earlier migration already
+// created, with no DROP or RENAME of it in between. It is a best-effort
+// heuristic on the statement text and never connects to the database.
+// Statements using IF NOT EXISTS are not flagged.
+func (m *Migrator) Lint() ([]LintWarning, error) {
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration files: %w", err)
+	}
+
+	createdBy := make(map[string]string)
+	var warnings []LintWarning
+	for _, file := range files {
+		version := versionOf(file)
+		f, err := m.openMigration(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %w", file, err)
+		}
+		scanner := newStatementScanner(file, f)
+		for {
+			stmt, err := scanner.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+
+			sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(stmt.sql, " "))
+			if match := lintCreatePattern.FindStringSubmatch(sql); match != nil {
+				// "IF NOT EXISTS" is idempotent and "ON" starts an unnamed index.
+				if strings.EqualFold(match[2], "IF") || strings.EqualFold(match[2], "ON") {
+					continue
+				}
+				name := normalizeLintName(match[2])
+				if other, ok := createdBy[name]; ok {
+					warnings = append(warnings, LintWarning{
+						Version: version,
+						Line:    stmt.line,
+						Message: fmt.Sprintf("%s %s is already created by %s", strings.ToLower(match[1]), name, other),
+					})
+				}
+				createdBy[name] = version
+			} else if match := lintDropPattern.FindStringSubmatch(sql); match != nil {
+				for _, name := range sqlNameRegexp.FindAllString(match[1], -1) {
+					delete(createdBy, normalizeLintName(name))
+				}
+			} else if match := lintRenamePattern.FindStringSubmatch(sql); match != nil {
+				from := normalizeLintName(match[1])
+				if _, ok := createdBy[from]; ok {
+					delete(createdBy, from)
+					createdBy[normalizeLintName(match[2])] = version
+				}
+			}
+		}
+		f.Close()
+	}
+	return warnings, nil
+}
+
+// normalizeLintName folds unquoted identifier parts to lower case and unquotes
+// quoted ones, as PostgreSQL resolves them.
+func normalizeLintName(name string) string {
+	parts := sqlIdentRegexp.FindAllString(name, -1)
+	for i, part := range parts {
+		if strings.HasPrefix(part, `"`) {
+			parts[i] = strings.ReplaceAll(part[1:len(part)-1], `""`, `"`)
+		} else {
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,38 @@
+package migrator
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLint(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_create_foo.sql":    &fstest.MapFile{Data: []byte("CREATE TABLE foo (id INT);\nCREATE INDEX ON foo (id);")},
+		"002_create_bar.sql":    &fstest.MapFile{Data: []byte("CREATE TABLE bar (id INT);\nCREATE INDEX ON bar (id);")},
+		"003_create_foo.sql":    &fstest.MapFile{Data: []byte("-- copied from 001\nCREATE TABLE \"foo\" (id INT);")},
+		"004_recreate_bar.sql":  &fstest.MapFile{Data: []byte("DROP TABLE IF EXISTS baz, bar;\nCREATE TABLE bar (id BIGINT);")},
+		"005_rename_bar.sql":    &fstest.MapFile{Data: []byte("ALTER TABLE bar RENAME TO bar_old;\nCREATE TABLE bar (id BIGINT);")},
+		"006_maybe_create.sql":  &fstest.MapFile{Data: []byte("CREATE TABLE IF NOT EXISTS foo (id INT);")},
+		"007_index_bar_old.sql": &fstest.MapFile{Data: []byte("CREATE UNIQUE INDEX CONCURRENTLY bar_old_id ON bar_old (id);\nCREATE INDEX bar_old_id ON bar_old (id);")},
+		"008_create_quoted.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE "odd ""name"", too" (id INT);`)},
+		"009_drop_quoted.sql":   &fstest.MapFile{Data: []byte(`DROP TABLE "odd ""name"", too";` + "\nCREATE TABLE \"odd \"\"name\"\", too\" (id BIGINT);")},
+	}
+	m := newFileMigrator(t, migrations)
+
+	warnings, err := m.Lint()
+	if err != nil {
+		t.Fatalf("failed to lint migrations: %v", err)
+	}
+	want := []string{
+		"003_create_foo:1: table foo is already created by 001_create_foo",
+		"007_index_bar_old:2: index bar_old_id is already created by 007_index_bar_old",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %v", len(want), warnings)
+	}
+	for i, w := range warnings {
+		if w.String() != want[i] {
+			t.Fatalf("warning %d = %q, want %q", i, w.String(), want[i])
+		}
+	}
+}
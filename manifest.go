@@ -0,0 +1,46 @@
+package migrator
+
+import (
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// orderByManifest returns the migration files in the order listed by the
+// WithManifest file. Listed files that do not exist are an error, as are
+// files missing from the manifest unless WithAllowUnlistedFiles is set.
+func (m *Migrator) orderByManifest(files []string) ([]string, error) {
+	data, err := fs.ReadFile(m.migrations, m.cfg.manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	listed := make(map[string]bool)
+	var ordered []string
+	for i, line := range strings.Split(string(data), "\n") {
+		file := strings.TrimSpace(line)
+		if file == "" || strings.HasPrefix(file, "#") {
+			continue
+		}
+		if listed[file] {
+			return nil, fmt.Errorf("%s:%d: %s is listed twice", m.cfg.manifest, i+1, file)
+		}
+		if !slices.Contains(files, file) {
+			return nil, fmt.Errorf("%s:%d: migration file %s not found", m.cfg.manifest, i+1, file)
+		}
+		listed[file] = true
+		ordered = append(ordered, file)
+	}
+
+	for _, file := range files {
+		if listed[file] {
+			continue
+		}
+		if !m.cfg.allowUnlisted {
+			return nil, fmt.Errorf("migration file %s is not listed in %s", file, m.cfg.manifest)
+		}
+		m.cfg.logger.Warn("ignored migration file not listed in manifest", "file", file, "manifest", m.cfg.manifest)
+	}
+	return ordered, nil
+}
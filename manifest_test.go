@@ -0,0 +1,53 @@
+package migrator
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestManifest(t *testing.T) {
+	migrations := func(manifest string) fstest.MapFS {
+		return fstest.MapFS{
+			"migrations.txt": &fstest.MapFile{Data: []byte(manifest)},
+			"a_insert.sql":   &fstest.MapFile{Data: []byte("INSERT INTO t VALUES (1);")},
+			"b_create.sql":   &fstest.MapFile{Data: []byte("CREATE TABLE t (id INT);")},
+		}
+	}
+
+	t.Run("orders by manifest", func(t *testing.T) {
+		m := newFileMigrator(t, migrations("# order matters\nb_create.sql\n\na_insert.sql\n"), WithManifest("migrations.txt"))
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			t.Fatalf("failed to get migration files: %v", err)
+		}
+		if expected := []string{"b_create.sql", "a_insert.sql"}; !slices.Equal(files, expected) {
+			t.Fatalf("expected %v, got %v", expected, files)
+		}
+	})
+
+	t.Run("missing file returns error", func(t *testing.T) {
+		m := newFileMigrator(t, migrations("b_create.sql\na_insert.sql\nc_missing.sql\n"), WithManifest("migrations.txt"))
+		if _, err := m.getMigrationFiles(); err == nil {
+			t.Fatal("expected error for missing file, got nil")
+		}
+	})
+
+	t.Run("unlisted file returns error", func(t *testing.T) {
+		m := newFileMigrator(t, migrations("b_create.sql\n"), WithManifest("migrations.txt"))
+		if _, err := m.getMigrationFiles(); err == nil {
+			t.Fatal("expected error for unlisted file, got nil")
+		}
+	})
+
+	t.Run("unlisted file is ignored when allowed", func(t *testing.T) {
+		m := newFileMigrator(t, migrations("b_create.sql\n"), WithManifest("migrations.txt"), WithAllowUnlistedFiles(true))
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			t.Fatalf("failed to get migration files: %v", err)
+		}
+		if expected := []string{"b_create.sql"}; !slices.Equal(files, expected) {
+			t.Fatalf("expected %v, got %v", expected, files)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+// migration is a migration file loaded for execution.
+type migration struct {
+	file       string
+	version    string
+	checksum   string
+	directives directives
+
+	// content holds the SQL of the file. It is empty for streamed files,
+	// which are read again statement by statement when applied.
+	content  string
+	streamed bool
+
+	// executed collects the statements run for WithAuditSink.
+	executed []string
+
+	// keepalive is shared by the run's migrations; nil without WithKeepalive.
+	keepalive *keepalive
+}
+
+// loadMigration reads a migration file, parsing its directives and computing
+// its checksum. Files above the stream threshold are scanned without being
+// held in memory.
+func (m *Migrator) loadMigration(file string) (*migration, error) {
+	if m.cfg.maxFileSize > 0 || m.canStream() {
+		info, err := fs.Stat(m.migrations, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat migration file %s: %w", file, err)
+		}
+		if m.cfg.maxFileSize > 0 && info.Size() > m.cfg.maxFileSize {
+			return nil, fmt.Errorf("migration file %s is %d bytes, exceeding the limit of %d bytes", file, info.Size(), m.cfg.maxFileSize)
+		}
+		if m.canStream() && info.Size() > m.cfg.streamThreshold {
+			return m.loadStreamedMigration(file)
+		}
+	}
+
+	content, err := m.readMigration(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration file %s: %w", file, err)
+	}
+	d, err := parseDirectives(file, string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse migration %s: %w", versionOf(file), err)
+	}
+
+	return &migration{
+		file:       file,
+		version:    versionOf(file),
+		checksum:   m.cfg.checksum(content),
+		directives: d,
+		content:    string(content),
+	}, nil
+}
+
+// Fingerprint returns a hash of the whole migration set: the version and
+// checksum of every migration file, in order. It is stable across calls and
+// changes whenever a migration is added, removed, renamed or edited, so CI can
+// use it to detect schema definition changes.
+func (m *Migrator) Fingerprint() (string, error) {
+	checksums, versions, err := m.checksums()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, version := range versions {
+		fmt.Fprintf(h, "%s\x00%s\n", version, checksums[version])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canStream reports whether large files may be streamed. Streaming executes
+// statement by statement and needs a checksum that can be computed
+// incrementally, so it is unavailable with a custom WithChecksum function.
+func (m *Migrator) canStream() bool {
+	return m.cfg.streamThreshold > 0 && m.cfg.splitStatements && m.cfg.checksumHash != nil
+}
+
+func (m *Migrator) loadStreamedMigration(file string) (*migration, error) {
+	f, err := m.openMigration(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration file %s: %w", file, err)
+	}
+	defer f.Close()
+
+	h := m.cfg.checksumHash()
+	d, err := parseDirectivesFrom(file, io.TeeReader(f, h))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse migration %s: %w", versionOf(file), err)
+	}
+
+	return &migration{
+		file:       file,
+		version:    versionOf(file),
+		checksum:   hex.EncodeToString(h.Sum(nil)),
+		directives: d,
+		streamed:   true,
+	}, nil
+}
+
+// execStreamedMigration executes a streamed migration statement by statement
+// as the file is read, so memory use is bounded by its largest statement.
+func (m *Migrator) execStreamedMigration(ctx context.Context, tx *sql.Tx, mig *migration) error {
+	f, err := m.openMigration(mig.file)
+	if err != nil {
+		return fmt.Errorf("failed to read migration file %s: %w", mig.file, err)
+	}
+	defer f.Close()
+
+	scanner := newStatementScanner(mig.file, f)
+	for i := 0; ; i++ {
+		stmt, err := scanner.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := m.execStatement(ctx, tx, mig, i, stmt); err != nil {
+			return err
+		}
+	}
+}
+
+// Statements returns the SQL statements of a migration as Run splits them
+// with WithStatementSplitting, without the terminating semicolons. It only
+// reads the migration files, so tooling can use it without a database. It
+// returns an error if no migration file has the version.
+func (m *Migrator) Statements(version string) ([]string, error) {
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration files: %w", err)
+	}
+
+	for _, file := range files {
+		if versionOf(file) != version {
+			continue
+		}
+		f, err := m.openMigration(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %w", file, err)
+		}
+		defer f.Close()
+
+		var stmts []string
+		scanner := newStatementScanner(file, f)
+		for {
+			stmt, err := scanner.next()
+			if err == io.EOF {
+				return stmts, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, stmt.sql)
+		}
+	}
+	return nil, fmt.Errorf("migration %s not found", version)
+}
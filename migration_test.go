@@ -0,0 +1,76 @@
+package migrator
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFingerprint(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_a.sql": &fstest.MapFile{Data: []byte("CREATE TABLE a (id INT);")},
+		"002_b.sql": &fstest.MapFile{Data: []byte("CREATE TABLE b (id INT);")},
+	}
+	m := newFileMigrator(t, migrations)
+
+	first, err := m.Fingerprint()
+	if err != nil {
+		t.Fatalf("failed to compute fingerprint: %v", err)
+	}
+	second, err := m.Fingerprint()
+	if err != nil {
+		t.Fatalf("failed to compute fingerprint: %v", err)
+	}
+	if first != second {
+		t.Fatalf("expected stable fingerprint, got %s and %s", first, second)
+	}
+
+	migrations["002_b.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id BIGINT);")}
+	edited, err := m.Fingerprint()
+	if err != nil {
+		t.Fatalf("failed to compute fingerprint: %v", err)
+	}
+	if edited == first {
+		t.Fatal("expected fingerprint to change when a file is modified")
+	}
+
+	delete(migrations, "002_b.sql")
+	removed, err := m.Fingerprint()
+	if err != nil {
+		t.Fatalf("failed to compute fingerprint: %v", err)
+	}
+	if removed == first || removed == edited {
+		t.Fatal("expected fingerprint to change when a file is removed")
+	}
+}
+
+func TestStatements(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);
+
+CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+	NEW.updated_at := now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+`)},
+	}
+	m := newFileMigrator(t, migrations)
+
+	stmts, err := m.Statements("001_create_users")
+	if err != nil {
+		t.Fatalf("failed to get statements: %v", err)
+	}
+	want := []string{
+		"CREATE TABLE users (id INT)",
+		"CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n\tNEW.updated_at := now();\n\tRETURN NEW;\nEND;\n$$ LANGUAGE plpgsql",
+	}
+	if !slices.Equal(stmts, want) {
+		t.Fatalf("Statements() = %q, want %q", stmts, want)
+	}
+
+	if _, err := m.Statements("999_missing"); err == nil {
+		t.Fatal("expected error for unknown version")
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
+	"path"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Migrator applies SQL migrations to a PostgreSQL database.
@@ -15,6 +19,11 @@ type Migrator struct {
 	db         *sql.DB
 	migrations fs.FS
 	cfg        config
+	table      string // quoted migrations table name
+	lockTable  string // quoted lock table name, used with WithRowLevelLock
+	tracker    Tracker
+	searchPath string    // quoted tenant schema set by RunForTenant
+	since      time.Time // cutoff set by RunSince
 }
 
 // New creates a new Migrator. Returns an error if db or migrations is nil.
@@ -31,10 +40,54 @@ func New(db *sql.DB, migrations fs.FS, opts ...Option) (*Migrator, error) {
 		opt(&cfg)
 	}
 
+	table, err := quoteTableName(cfg.tableName)
+	if err != nil {
+		return nil, fmt.Errorf("migrator: %w", err)
+	}
+
+	if len(cfg.params) > 0 && !cfg.splitStatements {
+		return nil, errors.New("migrator: WithMigrationParams requires WithStatementSplitting")
+	}
+	if cfg.tableLockRetries > 0 && cfg.tableLockTimeout <= 0 {
+		return nil, errors.New("migrator: WithTableLockRetry requires WithTableLockTimeout")
+	}
+	if cfg.minServerVersion != "" {
+		if _, err := serverVersionNum(cfg.minServerVersion); err != nil {
+			return nil, fmt.Errorf("migrator: %w", err)
+		}
+	}
+	if err := validateSessionSettings(cfg.sessionSettings); err != nil {
+		return nil, fmt.Errorf("migrator: %w", err)
+	}
+	if cfg.skipPattern != "" {
+		if _, err := path.Match(cfg.skipPattern, ""); err != nil {
+			return nil, fmt.Errorf("migrator: invalid skip pattern %q: %w", cfg.skipPattern, err)
+		}
+	}
+
+	if cfg.verifyChecksums && cfg.tracker != nil {
+		return nil, errors.New("migrator: WithVerifyChecksums requires the default tracker")
+	}
+
+	var lockTable string
+	if cfg.rowLevelLock {
+		if lockTable, err = quoteTableName(cfg.tableName + "_lock"); err != nil {
+			return nil, fmt.Errorf("migrator: %w", err)
+		}
+	}
+
+	tracker := cfg.tracker
+	if tracker == nil {
+		tracker = &tableTracker{table: table}
+	}
+
 	return &Migrator{
 		db:         db,
 		migrations: migrations,
 		cfg:        cfg,
+		table:      table,
+		lockTable:  lockTable,
+		tracker:    tracker,
 	}, nil
 }
 
@@ -47,6 +100,34 @@ func (m *Migrator) tryLock(ctx context.Context, conn *sql.Conn) (bool, error) {
 	return locked, nil
 }
 
+// lockTimeoutSetting formats d as a lock_timeout value, rounded up to whole
+// milliseconds. A timeout under a millisecond becomes 1ms rather than 0,
+// which would disable the timeout.
+func lockTimeoutSetting(d time.Duration) string {
+	return fmt.Sprintf("%dms", (d+time.Millisecond-1)/time.Millisecond)
+}
+
+// waitLock blocks until the advisory lock is released by its holder and
+// acquires it, giving up after the WithWaitForLock timeout.
+func (m *Migrator) waitLock(ctx context.Context, conn *sql.Conn) error {
+	if _, err := conn.ExecContext(ctx, `SELECT set_config('lock_timeout', $1, false)`, lockTimeoutSetting(m.cfg.waitForLock)); err != nil {
+		return fmt.Errorf("failed to set lock_timeout: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `RESET lock_timeout`); err != nil {
+			m.cfg.logger.Error("failed to reset lock_timeout", "error", err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, m.cfg.lockID); err != nil {
+		if sqlState(err) == "55P03" { // lock_not_available
+			return fmt.Errorf("another migration is in progress: still running after %s", m.cfg.waitForLock)
+		}
+		return fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+	return nil
+}
+
 func (m *Migrator) unlock(ctx context.Context, conn *sql.Conn) error {
 	var released bool
 	err := conn.QueryRowContext(ctx, `SELECT pg_advisory_unlock($1)`, m.cfg.lockID).Scan(&released)
@@ -59,62 +140,634 @@ func (m *Migrator) unlock(ctx context.Context, conn *sql.Conn) error {
 	return nil
 }
 
+// LockStatus reports whether the migration advisory lock is currently held,
+// and if so the process ID of the backend holding it. Operators can match
+// the PID against pg_stat_activity to diagnose a stuck deploy.
+func (m *Migrator) LockStatus(ctx context.Context) (held bool, holderPID int, err error) {
+	err = m.db.QueryRowContext(ctx, `
+		SELECT l.pid
+		FROM pg_locks l
+		JOIN pg_database d ON d.oid = l.database
+		WHERE l.locktype = 'advisory'
+		AND l.granted
+		AND l.objsubid = 1
+		AND d.datname = current_database()
+		AND l.classid::bigint = ($1::bigint >> 32) & 4294967295
+		AND l.objid::bigint = $1::bigint & 4294967295
+		LIMIT 1`, m.cfg.lockID).Scan(&holderPID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to query advisory lock: %w", err)
+	}
+	return true, holderPID, nil
+}
+
 // Run applies all pending migrations within a single transaction.
 func (m *Migrator) Run(ctx context.Context) error {
-	conn, err := m.db.Conn(ctx)
+	ctx, end := m.cfg.tracer.Start(ctx, "migrator.run")
+	var (
+		report    *runReport
+		confirmed []string
+		err       error
+	)
+	if m.cfg.confirm != nil {
+		// Asked before anything is locked, so no locks or transaction are
+		// held while waiting for the answer.
+		confirmed, err = m.confirmPending(ctx)
+	}
+	for attempt := 0; ; attempt++ {
+		report = &runReport{StartedAt: time.Now().UTC(), confirmed: confirmed}
+		if attempt == 0 && err != nil {
+			break
+		}
+		err = m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			return m.run(ctx, tx, report)
+		})
+		if err == nil || attempt >= m.cfg.runRetries || !isConnectionError(err) {
+			break
+		}
+
+		// The transaction rolled back with the connection, so the whole
+		// run can safely start over.
+		delay := m.cfg.runRetryBackoff << attempt
+		m.cfg.logger.Warn("retrying migrations after connection error", "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	end(err)
+	if err == nil {
+		// Only committed SQL is audited.
+		for _, entry := range report.audit {
+			m.cfg.auditSink(entry.version, m.cfg.redactSQL(entry.sql))
+		}
+	}
+	if m.cfg.reportFile != "" {
+		if reportErr := m.writeReport(report, err); reportErr != nil {
+			if err != nil {
+				m.cfg.logger.Error("failed to write report", "error", reportErr)
+				return err
+			}
+			return reportErr
+		}
+	}
+	if err == nil && report.PausedAt != "" {
+		return &PausedError{Version: report.PausedAt}
+	}
+	return err
+}
+
+func (m *Migrator) run(ctx context.Context, tx *sql.Tx, report *runReport) error {
+	if m.cfg.minServerVersion != "" {
+		if err := m.checkServerVersion(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	applied, err := m.getAppliedMigrations(ctx, tx)
 	if err != nil {
-		return fmt.Errorf("failed to acquire database connection: %w", err)
+		return fmt.Errorf("failed to get applied migrations: %w", err)
 	}
-	defer conn.Close()
 
-	locked, err := m.tryLock(ctx, conn)
+	files, err := m.getMigrationFiles()
 	if err != nil {
-		return fmt.Errorf("failed to acquire advisory lock: %w", err)
+		return fmt.Errorf("failed to get migration files: %w", err)
 	}
-	if !locked {
-		return fmt.Errorf("another migration is in progress")
+	before, err := m.tableSizes(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 && m.cfg.requireMigrations {
+		return errors.New("no migration files found")
+	}
+
+	renamed, err := renamedVersions(m.cfg.scheme, files, applied)
+	if err != nil {
+		return err
+	}
+	if err := m.verifyRenames(ctx, tx, files, renamed); err != nil {
+		return err
+	}
+	if m.cfg.verifyChecksums {
+		if err := m.verifyChecksums(ctx, tx, files, renamed); err != nil {
+			return err
+		}
+	}
+	if m.cfg.requireAppliedFiles {
+		if err := checkAppliedFiles(files, applied, renamed); err != nil {
+			return err
+		}
+	}
+	for version, old := range renamed {
+		if err := m.recordRename(ctx, tx, old, version); err != nil {
+			return err
+		}
+		m.cfg.logger.Warn("migration renamed after it was applied", "version", version, "applied_as", old)
+		applied[version] = true
+	}
+
+	for _, file := range files {
+		if version := versionOf(file); applied[version] {
+			report.initialHead = version
+		}
+	}
+	report.Head = report.initialHead
+
+	if report.confirmed != nil {
+		if err := m.checkConfirmed(files, applied, report.confirmed); err != nil {
+			return err
+		}
+	}
+
+	// With the default options only pending files are read and hashed, so a
+	// run against an up-to-date database costs a directory listing however
+	// many migrations there are. WithVerifyChecksums reads every applied
+	// file and WithSkipUnreadable opens every file, WithManifest reads the
+	// manifest, and WithConfirm reads the pending files a second time.
+	keepalive := m.newKeepalive()
+	for _, file := range files {
+		version := versionOf(file)
+		if applied[version] {
+			report.Head = version
+			continue
+		}
+
+		if m.beforeSince(file) {
+			m.cfg.logger.Info("skipped migration", "version", version, "reason", "before cutoff", "since", m.since)
+			continue
+		}
+
+		if m.skipsPattern(version) {
+			m.cfg.logger.Warn("skipped migration matching skip pattern; later migrations run without it", "version", version, "pattern", m.cfg.skipPattern)
+			continue
+		}
+
+		mig, err := m.loadMigration(file)
+		if err != nil {
+			return err
+		}
+		if !mig.directives.runsIn(m.cfg.environment) {
+			// Recorded like a skip-if, so it is not pending in this
+			// environment's Status and later runs do not reconsider it.
+			if err := m.recordMigration(ctx, tx, mig, true); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", version, err)
+			}
+			report.Migrations = append(report.Migrations, reportEntry{Version: version, Checksum: mig.checksum, AppliedAt: time.Now().UTC(), Skipped: true})
+			report.Head = version
+			m.cfg.logger.Info("skipped migration", "version", version, "reason", "environment", "environment", m.cfg.environment)
+			continue
+		}
+		if !mig.directives.runsAs(m.cfg.runType) {
+			m.cfg.logger.Info("skipped migration", "version", version, "reason", "type", "data", mig.directives.data)
+			continue
+		}
+
+		mig.keepalive = keepalive
+
+		entry := reportEntry{Version: version, Checksum: mig.checksum, AppliedAt: time.Now().UTC()}
+		spanCtx, end := m.cfg.tracer.Start(ctx, "migrator.migration", slog.String("version", version))
+		err = m.runMigration(spanCtx, tx, mig, &entry)
+		end(err)
+		elapsed := time.Since(entry.AppliedAt)
+		entry.DurationMS = elapsed.Milliseconds()
+		if m.cfg.slowThreshold > 0 && elapsed > m.cfg.slowThreshold {
+			entry.Slow = true
+			m.cfg.logger.Warn("slow migration", "version", version, "duration", elapsed, "threshold", m.cfg.slowThreshold)
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		report.Migrations = append(report.Migrations, entry)
+		if err != nil {
+			return err
+		}
+		if m.cfg.auditSink != nil && len(mig.executed) > 0 {
+			report.audit = append(report.audit, auditEntry{version: version, sql: strings.Join(mig.executed, ";\n")})
+		}
+		report.Head = version
+		if mig.directives.pause {
+			m.cfg.logger.Info("paused migrations", "version", version)
+			report.PausedAt = version
+			break
+		}
+	}
+
+	if report.applied() > 0 {
+		for i, stmt := range m.cfg.postMigrationSQL {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to run post-migration statement %d: %w", i+1, err)
+			}
+		}
+	}
+	return m.reportTableSizes(ctx, tx, report, before)
+}
+
+// checkServerVersion fails when the server is older than the
+// WithMinServerVersion requirement.
+func (m *Migrator) checkServerVersion(ctx context.Context, tx *sql.Tx) error {
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT current_setting('server_version_num')::int`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to get server version: %w", err)
+	}
+	return checkMinServerVersion(current, m.cfg.minServerVersion)
+}
+
+// recordRename updates the version recorded for a renamed migration file, so
+// the rename is detected once. A custom tracker cannot be updated, and keeps
+// the old version.
+func (m *Migrator) recordRename(ctx context.Context, tx *sql.Tx, old, version string) error {
+	if m.cfg.tracker != nil {
+		return nil
+	}
+	query := fmt.Sprintf("UPDATE %s SET version = $1 WHERE version = $2", m.table)
+	if _, err := tx.ExecContext(ctx, query, version, old); err != nil {
+		return fmt.Errorf("failed to record rename of migration %s to %s: %w", old, version, err)
+	}
+	return nil
+}
+
+// checkAppliedFiles returns an error listing the applied versions that no
+// longer have a migration file. Renamed files count as present.
+func checkAppliedFiles(files []string, applied map[string]bool, renamed map[string]string) error {
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[versionOf(file)] = true
+	}
+	for _, old := range renamed {
+		present[old] = true
+	}
+
+	var missing []string
+	for version := range applied {
+		if !present[version] {
+			missing = append(missing, version)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("applied migrations have no file: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// skipsPattern reports whether version matches the WithSkipPattern glob.
+func (m *Migrator) skipsPattern(version string) bool {
+	if m.cfg.skipPattern == "" {
+		return false
+	}
+	matched, _ := path.Match(m.cfg.skipPattern, version) // validated in New
+	return matched
+}
+
+// runMigration applies mig, or records it as skipped when its skip-if
+// predicate holds.
+func (m *Migrator) runMigration(ctx context.Context, tx *sql.Tx, mig *migration, entry *reportEntry) error {
+	if m.cfg.preMigrationCheck != nil {
+		if err := m.cfg.preMigrationCheck(ctx, tx, mig.version); err != nil {
+			return fmt.Errorf("pre-migration check failed for migration %s: %w", mig.version, err)
+		}
+	}
+
+	if mig.directives.skipIf != "" {
+		var skip bool
+		if err := tx.QueryRowContext(ctx, "SELECT ("+mig.directives.skipIf+")").Scan(&skip); err != nil {
+			return fmt.Errorf("failed to evaluate skip-if predicate of migration %s: %w", mig.version, err)
+		}
+		if skip {
+			if err := m.recordMigration(ctx, tx, mig, true); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", mig.version, err)
+			}
+			entry.Skipped = true
+			m.cfg.logger.Info("skipped migration", "version", mig.version, "reason", "skip-if")
+			return nil
+		}
+	}
+
+	for _, table := range mig.directives.backups {
+		if m.cfg.backupHandler == nil {
+			return fmt.Errorf("migration %s requests a backup of %s but no backup handler is configured", mig.version, table)
+		}
+		if err := m.cfg.backupHandler(ctx, tx, table); err != nil {
+			return fmt.Errorf("failed to back up %s before migration %s: %w", table, mig.version, err)
+		}
+		m.cfg.logger.Info("backed up table", "version", mig.version, "table", table)
+	}
+
+	for _, id := range mig.directives.appLocks {
+		if err := m.appLock(ctx, tx, id); err != nil {
+			return fmt.Errorf("migration %s: %w", mig.version, err)
+		}
+	}
+
+	if err := m.applyClassified(ctx, tx, mig); err != nil {
+		return fmt.Errorf("failed to apply migration %s: %w", mig.version, err)
+	}
+	m.cfg.logger.Info("applied migration", "version", mig.version)
+	return nil
+}
+
+// appLock takes the application advisory lock of an app-lock directive for
+// the rest of the transaction, so application code holding the same lock
+// gates the migration and does not see its changes half applied. Like the
+// migration lock, it fails at once when the lock is held, or waits up to the
+// WithWaitForLock timeout.
+func (m *Migrator) appLock(ctx context.Context, tx *sql.Tx, id int64) error {
+	if m.cfg.waitForLock <= 0 {
+		var locked bool
+		if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, id).Scan(&locked); err != nil {
+			return fmt.Errorf("failed to acquire application lock %d: %w", id, err)
+		}
+		if !locked {
+			return fmt.Errorf("application lock %d is held by another session", id)
+		}
+		return nil
+	}
+
+	m.cfg.logger.Info("waiting for application lock", "lock_id", id, "timeout", m.cfg.waitForLock)
+	return withLocalLockTimeout(ctx, tx, m.cfg.waitForLock, func() error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, id); err != nil {
+			if sqlState(err) == "55P03" { // lock_not_available
+				return fmt.Errorf("application lock %d still held after %s", id, m.cfg.waitForLock)
+			}
+			return fmt.Errorf("failed to acquire application lock %d: %w", id, err)
+		}
+		return nil
+	})
+}
+
+// ImportHistory records the given versions as applied without running their
+// SQL, e.g. when adopting the migrator from another tool's history table.
+// Every version must match a migration file. Versions are recorded in
+// migration order and already applied versions are skipped, so importing the
+// same history twice is a no-op.
+func (m *Migrator) ImportHistory(ctx context.Context, versions []string) error {
+	return m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		applied, err := m.getAppliedMigrations(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+
+		requested := make(map[string]bool, len(versions))
+		for _, version := range versions {
+			requested[version] = true
+		}
+
+		for _, file := range files {
+			version := versionOf(file)
+			if !requested[version] {
+				continue
+			}
+			delete(requested, version)
+			if applied[version] {
+				continue
+			}
+			mig, err := m.loadMigration(file)
+			if err != nil {
+				return err
+			}
+			if err := m.recordMigration(ctx, tx, mig, false); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", version, err)
+			}
+			m.cfg.logger.Info("imported migration", "version", version)
+		}
+
+		for _, version := range versions {
+			if requested[version] {
+				return fmt.Errorf("migration %s not found", version)
+			}
+		}
+		return nil
+	})
+}
+
+// MarkAllApplied records every migration file as applied without running its
+// SQL, for adopting the migrator on a database that already has the full
+// current schema. Already recorded versions are skipped, so marking twice is
+// a no-op.
+func (m *Migrator) MarkAllApplied(ctx context.Context) error {
+	return m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		applied, err := m.getAppliedMigrations(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+
+		for _, file := range files {
+			version := versionOf(file)
+			if applied[version] {
+				continue
+			}
+			mig, err := m.loadMigration(file)
+			if err != nil {
+				return err
+			}
+			if err := m.recordMigration(ctx, tx, mig, false); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", version, err)
+			}
+			m.cfg.logger.Info("marked migration applied", "version", version)
+		}
+		return nil
+	})
+}
+
+// MarkAppliedOne records a single version as applied without running its
+// SQL, e.g. after the migration was applied by hand. It returns an error if
+// the version is already recorded or has no migration file. Unlike
+// ImportHistory, which skips recorded versions, marking is never silently a
+// no-op.
+func (m *Migrator) MarkAppliedOne(ctx context.Context, version string) error {
+	return m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		applied, err := m.getAppliedMigrations(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+		if applied[version] {
+			return fmt.Errorf("migration %s is already applied", version)
+		}
+
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+
+		for _, file := range files {
+			if versionOf(file) != version {
+				continue
+			}
+			mig, err := m.loadMigration(file)
+			if err != nil {
+				return err
+			}
+			if err := m.recordMigration(ctx, tx, mig, false); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", version, err)
+			}
+			m.cfg.logger.Info("marked migration applied", "version", version)
+			return nil
+		}
+		return fmt.Errorf("migration %s not found", version)
+	})
+}
+
+// Supersede replaces a migration that failed with a later fix file. Since a
+// failed run rolls back entirely, the old version is still pending and would
+// fail again; Supersede records it as skipped without running its SQL, so the
+// next Run applies newVersion in its place. Both versions must be pending
+// migration files and newVersion must come after oldVersion.
+func (m *Migrator) Supersede(ctx context.Context, oldVersion, newVersion string) error {
+	return m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		applied, err := m.getAppliedMigrations(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+		for _, version := range []string{oldVersion, newVersion} {
+			if applied[version] {
+				return fmt.Errorf("migration %s is already applied", version)
+			}
+		}
+
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+
+		oldFile := ""
+		for _, file := range files {
+			switch versionOf(file) {
+			case oldVersion:
+				oldFile = file
+			case newVersion:
+				if oldFile == "" {
+					return fmt.Errorf("migration %s must come after the superseded migration %s", newVersion, oldVersion)
+				}
+				mig, err := m.loadMigration(oldFile)
+				if err != nil {
+					return err
+				}
+				if err := m.recordMigration(ctx, tx, mig, true); err != nil {
+					return fmt.Errorf("failed to record migration %s: %w", oldVersion, err)
+				}
+				m.cfg.logger.Warn("superseded migration", "version", oldVersion, "superseded_by", newVersion, "applied_by", m.cfg.appliedBy)
+				return nil
+			}
+		}
+		if oldFile == "" {
+			return fmt.Errorf("migration %s not found", oldVersion)
+		}
+		return fmt.Errorf("migration %s not found", newVersion)
+	})
+}
+
+// withLockedTx acquires the advisory lock on a dedicated connection, ensures
+// the migrations table exists and locks it, then runs fn within a single
+// transaction that is committed if fn succeeds.
+func (m *Migrator) withLockedTx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	phase := PhaseConnect
+	defer func() {
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = &DeadlineError{Phase: phase, Err: err}
+		}
+	}()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database connection: %w", err)
 	}
+	defer conn.Close()
+
+	// Session-level settings changed by the migrator or by migrations (SET
+	// without LOCAL) outlive the transaction, so restore the connection's
+	// defaults before it returns to the pool.
 	defer func() {
-		if err := m.unlock(context.Background(), conn); err != nil {
-			m.cfg.logger.Error("failed to release advisory lock", "error", err)
+		if _, err := conn.ExecContext(context.Background(), `RESET ALL`); err != nil {
+			m.cfg.logger.Error("failed to reset session settings", "error", err)
 		}
 	}()
 
+	if m.cfg.applicationName != "" {
+		if _, err := conn.ExecContext(ctx, `SELECT set_config('application_name', $1, false)`, m.cfg.applicationName); err != nil {
+			return fmt.Errorf("failed to set application_name: %w", err)
+		}
+	}
+
+	phase = PhaseLock
+	if m.cfg.lockStrategy == AdvisoryLock && !m.cfg.transactionLock {
+		locked, err := m.tryLock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("failed to acquire advisory lock: %w", err)
+		}
+		if !locked && m.cfg.waitForLock > 0 {
+			m.cfg.logger.Info("waiting for another migration to finish", "timeout", m.cfg.waitForLock)
+			if err := m.waitLock(ctx, conn); err != nil {
+				return err
+			}
+			locked = true
+		}
+		if !locked {
+			return fmt.Errorf("another migration is in progress")
+		}
+		defer func() {
+			if err := m.unlock(context.Background(), conn); err != nil {
+				m.cfg.logger.Error("failed to release advisory lock", "error", err)
+			}
+		}()
+	}
+
 	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	if err := m.createMigrationsTable(ctx, tx); err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+	if m.searchPath != "" {
+		if _, err := tx.ExecContext(ctx, `SELECT set_config('search_path', $1 || ', ' || current_setting('search_path'), true)`, m.searchPath); err != nil {
+			return fmt.Errorf("failed to set search_path: %w", err)
+		}
 	}
 
-	lockQuery := fmt.Sprintf(`LOCK TABLE %s IN ACCESS EXCLUSIVE MODE`, m.cfg.tableName)
-	if _, err := tx.ExecContext(ctx, lockQuery); err != nil {
-		return fmt.Errorf("failed to lock %s: %w", m.cfg.tableName, err)
+	if err := m.setSessionSettings(ctx, tx); err != nil {
+		return err
 	}
 
-	applied, err := m.getAppliedMigrations(ctx, tx)
-	if err != nil {
-		return fmt.Errorf("failed to get applied migrations: %w", err)
+	if m.cfg.lockStrategy == AdvisoryLock && m.cfg.transactionLock {
+		if err := m.xactLock(ctx, tx); err != nil {
+			return err
+		}
 	}
 
-	files, err := m.getMigrationFiles()
-	if err != nil {
-		return fmt.Errorf("failed to get migration files: %w", err)
-	}
+	if m.cfg.tracker == nil {
+		if err := m.createMigrationsTable(ctx, tx); err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
 
-	for _, file := range files {
-		version := strings.TrimSuffix(file, ".sql")
-		if !applied[version] {
-			if err := m.applyMigration(ctx, tx, version, file); err != nil {
-				return fmt.Errorf("failed to apply migration %s: %w", version, err)
+		if m.cfg.lockStrategy != NoLock {
+			if err := m.lockMigrationsTable(ctx, tx); err != nil {
+				return err
 			}
-			m.cfg.logger.Info("applied migration", "version", version)
 		}
 	}
 
+	phase = PhaseMigrate
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit migrations: %w", err)
 	}
@@ -122,36 +775,250 @@ func (m *Migrator) Run(ctx context.Context) error {
 	return nil
 }
 
+// lockMigrationsTable locks the migrations table for the rest of the
+// transaction, failing with ErrTableLocked when a lock timeout is configured
+// and expires. With WithRowLevelLock it locks the sentinel row of the lock
+// table instead, leaving the migrations table readable.
+func (m *Migrator) lockMigrationsTable(ctx context.Context, tx *sql.Tx) error {
+	lockQuery := fmt.Sprintf(`LOCK TABLE %s IN ACCESS EXCLUSIVE MODE`, m.table)
+	if m.cfg.rowLevelLock {
+		setup := fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS %s (id INT PRIMARY KEY);
+			INSERT INTO %[1]s (id) VALUES (1) ON CONFLICT DO NOTHING;`, m.lockTable)
+		if _, err := tx.ExecContext(ctx, setup); err != nil {
+			return fmt.Errorf("failed to create lock table: %w", err)
+		}
+		lockQuery = fmt.Sprintf(`SELECT id FROM %s WHERE id = 1 FOR UPDATE`, m.lockTable)
+	}
+	lock := func() error {
+		return withLocalLockTimeout(ctx, tx, m.cfg.tableLockTimeout, func() error {
+			if _, err := tx.ExecContext(ctx, lockQuery); err != nil {
+				if sqlState(err) == "55P03" { // lock_not_available
+					return fmt.Errorf("%w: %s not locked within %s", ErrTableLocked, m.cfg.tableName, m.cfg.tableLockTimeout)
+				}
+				return fmt.Errorf("failed to lock %s: %w", m.cfg.tableName, err)
+			}
+			return nil
+		})
+	}
+	if m.cfg.tableLockRetries <= 0 {
+		return lock()
+	}
+
+	// A lock timeout aborts the transaction, so each attempt runs in a
+	// savepoint that is rolled back before the next one.
+	for attempt := 0; ; attempt++ {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT migrator_table_lock`); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		err := lock()
+		if err == nil {
+			if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT migrator_table_lock`); err != nil {
+				return fmt.Errorf("failed to release savepoint: %w", err)
+			}
+			return nil
+		}
+		if attempt >= m.cfg.tableLockRetries || !errors.Is(err, ErrTableLocked) {
+			return err
+		}
+		if _, rollbackErr := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT migrator_table_lock`); rollbackErr != nil {
+			return fmt.Errorf("failed to roll back to savepoint: %w", rollbackErr)
+		}
+
+		delay := m.cfg.tableLockBackoff << attempt
+		m.cfg.logger.Warn("retrying migrations table lock", "attempt", attempt+1, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// xactLock acquires the advisory lock for the duration of tx, used instead of
+// the session lock with WithTransactionLock. The lock is released when tx
+// commits or rolls back.
+func (m *Migrator) xactLock(ctx context.Context, tx *sql.Tx) error {
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, m.cfg.lockID).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to acquire advisory lock: %w", err)
+	}
+	if locked {
+		return nil
+	}
+	if m.cfg.waitForLock <= 0 {
+		return fmt.Errorf("another migration is in progress")
+	}
+
+	m.cfg.logger.Info("waiting for another migration to finish", "timeout", m.cfg.waitForLock)
+	return withLocalLockTimeout(ctx, tx, m.cfg.waitForLock, func() error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, m.cfg.lockID); err != nil {
+			if sqlState(err) == "55P03" { // lock_not_available
+				return fmt.Errorf("another migration is in progress: still running after %s", m.cfg.waitForLock)
+			}
+			return fmt.Errorf("failed to acquire advisory lock: %w", err)
+		}
+		return nil
+	})
+}
+
+// withLocalLockTimeout runs fn with lock_timeout set to d within tx, then
+// restores the previous value. A zero d runs fn unchanged; a d under a
+// millisecond is rounded up rather than disabling the timeout.
+func withLocalLockTimeout(ctx context.Context, tx *sql.Tx, d time.Duration, fn func() error) error {
+	if d <= 0 {
+		return fn()
+	}
+
+	var previous string
+	if err := tx.QueryRowContext(ctx, `SELECT current_setting('lock_timeout')`).Scan(&previous); err != nil {
+		return fmt.Errorf("failed to read lock_timeout: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('lock_timeout', $1, true)`, lockTimeoutSetting(d)); err != nil {
+		return fmt.Errorf("failed to set lock_timeout: %w", err)
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT set_config('lock_timeout', $1, true)`, previous); err != nil {
+		return fmt.Errorf("failed to restore lock_timeout: %w", err)
+	}
+	return nil
+}
+
 func (m *Migrator) createMigrationsTable(ctx context.Context, tx *sql.Tx) error {
-	query := fmt.Sprintf(`
+	exists, err := m.tableExists(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if exists {
+		current, err := m.checkTableShape(ctx, tx)
+		if err != nil {
+			return err
+		}
+		// ALTER TABLE takes an ACCESS EXCLUSIVE lock even when the column
+		// exists, so skip the DDL for an up-to-date table.
+		if current {
+			return nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, migrationsTableDDL(m.table, m.cfg.timestampTZ)); err != nil {
+		return err
+	}
+
+	if !exists && m.cfg.onTableCreated != nil {
+		if err := m.cfg.onTableCreated(ctx, tx); err != nil {
+			return fmt.Errorf("table created hook failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// migrationsTableDDL returns the statements that create the migrations table
+// or add columns missing from older versions of it.
+func migrationsTableDDL(table string, timestampTZ bool) string {
+	typ := "TIMESTAMP"
+	if timestampTZ {
+		typ = "TIMESTAMPTZ"
+	}
+	ddl := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
 			version TEXT PRIMARY KEY,
-			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		);`, m.cfg.tableName)
-
-	_, err := tx.ExecContext(ctx, query)
-	return err
+			applied_at %s DEFAULT CURRENT_TIMESTAMP
+		);
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS applied_by TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS checksum TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS skipped BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS server_version TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS release TEXT;
+		ALTER TABLE %[1]s ADD COLUMN IF NOT EXISTS path TEXT;`, table, typ)
+	if timestampTZ {
+		// Converts a TIMESTAMP column of an older table, interpreting its
+		// values in the session time zone they were written in.
+		ddl += fmt.Sprintf(`
+		ALTER TABLE %s ALTER COLUMN applied_at TYPE TIMESTAMPTZ;`, table)
+	}
+	return ddl
 }
 
-func (m *Migrator) getAppliedMigrations(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
-	applied := make(map[string]bool)
+// checkTableShape returns a descriptive error when the existing migrations
+// table was not created by this package, e.g. golang-migrate's single-row
+// table of a numeric version and a dirty flag. current reports whether the
+// table already has every column added since the first release.
+func (m *Migrator) checkTableShape(ctx context.Context, tx *sql.Tx) (current bool, err error) {
+	columns, err := m.tableColumns(ctx, tx)
+	if err != nil {
+		return false, err
+	}
 
-	query := fmt.Sprintf("SELECT version FROM %s", m.cfg.tableName)
-	rows, err := tx.QueryContext(ctx, query)
+	if columns["version"] == "text" {
+		if m.cfg.timestampTZ && columns["applied_at"] != "timestamp with time zone" {
+			return false, nil
+		}
+		for _, column := range []string{"applied_by", "checksum", "skipped", "server_version", "release", "path"} {
+			if _, ok := columns[column]; !ok {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	tool := "another migration tool"
+	if _, ok := columns["dirty"]; ok {
+		tool = "golang-migrate"
+	}
+	return false, fmt.Errorf("%s has the layout of %s, not this migrator; use WithTableName to choose another table and ImportHistory to adopt the applied versions", m.cfg.tableName, tool)
+}
+
+// tableColumns returns the type of each column of the migrations table by
+// name.
+func (m *Migrator) tableColumns(ctx context.Context, tx *sql.Tx) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT attname, format_type(atttypid, atttypmod)
+		FROM pg_attribute
+		WHERE attrelid = to_regclass($1)
+		AND attnum > 0
+		AND NOT attisdropped`, m.table)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to inspect %s: %w", m.cfg.tableName, err)
 	}
 	defer rows.Close()
 
+	columns := make(map[string]string)
 	for rows.Next() {
-		var version string
-		if err := rows.Scan(&version); err != nil {
-			return nil, err
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", m.cfg.tableName, err)
 		}
-		applied[version] = true
+		columns[name] = typ
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", m.cfg.tableName, err)
+	}
+	return columns, nil
+}
 
-	return applied, rows.Err()
+func (m *Migrator) tableExists(ctx context.Context, tx *sql.Tx) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, m.table).Scan(&exists)
+	return exists, err
+}
+
+func (m *Migrator) getAppliedMigrations(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	versions, err := m.tracker.AppliedVersions(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
 }
 
 func (m *Migrator) getMigrationFiles() ([]string, error) {
@@ -161,30 +1028,218 @@ func (m *Migrator) getMigrationFiles() ([]string, error) {
 	}
 
 	var files []string
+	versions := make(map[string]string)
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
-			files = append(files, entry.Name())
+		if entry.IsDir() || !m.isMigrationFile(entry.Name()) {
+			continue
+		}
+		if other, ok := versions[versionOf(entry.Name())]; ok {
+			return nil, fmt.Errorf("duplicate migration version in %s and %s", other, entry.Name())
 		}
+		versions[versionOf(entry.Name())] = entry.Name()
+		if m.cfg.skipUnreadable {
+			f, err := m.migrations.Open(entry.Name())
+			if err != nil {
+				m.cfg.logger.Warn("skipped unreadable migration file", "file", entry.Name(), "error", err)
+				continue
+			}
+			f.Close()
+		}
+		files = append(files, entry.Name())
 	}
 
-	sort.Strings(files)
+	if m.cfg.manifest != "" {
+		return m.orderByManifest(files)
+	}
+	if err := m.cfg.scheme.sortFiles(files); err != nil {
+		return nil, err
+	}
 	return files, nil
 }
 
-func (m *Migrator) applyMigration(ctx context.Context, tx *sql.Tx, version string, file string) error {
-	content, err := fs.ReadFile(m.migrations, file)
-	if err != nil {
-		return fmt.Errorf("failed to read migration file: %w", err)
+// restoreDeferredSQL makes INITIALLY DEFERRED constraints deferred again
+// after SET CONSTRAINTS ALL IMMEDIATE, which switches every deferrable
+// constraint to immediate for the rest of the transaction. SET CONSTRAINTS
+// works by name, so a deferrable constraint sharing a schema and name with an
+// initially deferred one is deferred too.
+const restoreDeferredSQL = `DO $$
+DECLARE
+	names TEXT;
+BEGIN
+	SELECT string_agg(DISTINCT format('%I.%I', n.nspname, c.conname), ', ')
+	INTO names
+	FROM pg_constraint c
+	JOIN pg_namespace n ON n.oid = c.connamespace
+	WHERE c.condeferred;
+	IF names IS NOT NULL THEN
+		EXECUTE 'SET CONSTRAINTS ' || names || ' DEFERRED';
+	END IF;
+END
+$$`
+
+func (m *Migrator) applyMigration(ctx context.Context, tx *sql.Tx, mig *migration) error {
+	if mig.directives.deferConstraints {
+		if _, err := tx.ExecContext(ctx, `SET CONSTRAINTS ALL DEFERRED`); err != nil {
+			return fmt.Errorf("failed to defer constraints: %w", err)
+		}
+	}
+	if err := m.execMigration(ctx, tx, mig); err != nil {
+		return err
+	}
+	if mig.directives.deferConstraints {
+		// Check the deferred constraints now, so violations are reported
+		// for this migration, then restore the declared defaults for later
+		// migrations and the post-migration SQL.
+		if _, err := tx.ExecContext(ctx, `SET CONSTRAINTS ALL IMMEDIATE`); err != nil {
+			return fmt.Errorf("deferred constraint check failed: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, restoreDeferredSQL); err != nil {
+			return fmt.Errorf("failed to restore deferred constraints: %w", err)
+		}
 	}
 
-	if _, err := tx.ExecContext(ctx, string(content)); err != nil {
+	return m.recordMigration(ctx, tx, mig, false)
+}
+
+// execMigration runs the SQL of a migration file, statement by statement when
+// statement splitting is enabled. Unless the file is streamed, it is split in
+// full before any statement runs so a truncated file fails without executing
+// anything.
+func (m *Migrator) execMigration(ctx context.Context, tx *sql.Tx, mig *migration) error {
+	if err := mig.keepalive.ping(ctx, tx); err != nil {
 		return err
 	}
+	if mig.streamed {
+		return m.execStreamedMigration(ctx, tx, mig)
+	}
+	if !m.cfg.splitStatements {
+		// Split only to analyze; the file still runs as one command, and
+		// the server reports any syntax error.
+		stmts, _ := splitStatements(mig.file, mig.content)
+		for _, stmt := range stmts {
+			if err := checkTransactionControl(stmt); err != nil {
+				return err
+			}
+			m.warnRewrite(mig, stmt)
+		}
+		if _, err := tx.ExecContext(ctx, m.statementTag(mig)+mig.content); err != nil {
+			return err
+		}
+		mig.keepalive.touch()
+		m.audit(mig, mig.content)
+		return nil
+	}
 
-	insertQuery := fmt.Sprintf("INSERT INTO %s (version) VALUES ($1)", m.cfg.tableName)
-	if _, err := tx.ExecContext(ctx, insertQuery, version); err != nil {
+	stmts, err := splitStatements(mig.file, mig.content)
+	if err != nil {
 		return err
 	}
+	for i, stmt := range stmts {
+		if err := m.execStatement(ctx, tx, mig, i, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// execStatement runs the index'th statement of mig.
+func (m *Migrator) execStatement(ctx context.Context, tx *sql.Tx, mig *migration, index int, stmt statement) error {
+	query, args := stmt.sql, []any(nil)
+	if len(m.cfg.params) > 0 {
+		var err error
+		if query, args, err = bindParams(stmt.sql, m.cfg.params); err != nil {
+			return fmt.Errorf("statement at line %d: %w", stmt.line, err)
+		}
+	}
+	if err := checkTransactionControl(stmt); err != nil {
+		return err
+	}
+	if err := mig.keepalive.ping(ctx, tx); err != nil {
+		return fmt.Errorf("statement at line %d: %w", stmt.line, err)
+	}
+	m.warnRewrite(mig, stmt)
+	tag := m.statementTag(mig)
+	start := time.Now()
+	if _, err := tx.ExecContext(ctx, tag+query, args...); err != nil {
+		if pos := errorPosition(err) - utf8.RuneCountInString(tag); pos > 0 && len(args) == 0 {
+			line, column := stmt.position(pos)
+			return fmt.Errorf("statement at line %d: error at line %d, column %d: %w", stmt.line, line, column, err)
+		}
+		return fmt.Errorf("statement at line %d: %w", stmt.line, err)
+	}
+	mig.keepalive.touch()
+	m.audit(mig, query)
+	if m.cfg.statementTiming {
+		m.cfg.logger.Info("executed statement",
+			"version", mig.version,
+			"statement", index+1,
+			"line", stmt.line,
+			"duration", time.Since(start),
+			"sql", snippet(m.cfg.redactSQL(stmt.sql)))
+	}
 	return nil
 }
+
+// snippet shortens a statement to its first line, truncated, for logging.
+func snippet(sql string) string {
+	const maxLen = 60
+	line, _, more := strings.Cut(sql, "\n")
+	if r := []rune(line); len(r) > maxLen {
+		line, more = string(r[:maxLen]), true
+	}
+	if more {
+		line += "..."
+	}
+	return line
+}
+
+// audit records SQL executed for mig when WithAuditSink is set.
+func (m *Migrator) audit(mig *migration, sql string) {
+	if m.cfg.auditSink != nil {
+		mig.executed = append(mig.executed, strings.TrimSpace(sql))
+	}
+}
+
+// statementTag returns the comment prepended to the SQL of mig with
+// WithStatementTag, or "" when no tag is set.
+func (m *Migrator) statementTag(mig *migration) string {
+	if m.cfg.statementTag == "" {
+		return ""
+	}
+	comment := strings.ReplaceAll(m.cfg.statementTag+": "+mig.version, "*/", "* /")
+	return "/* " + comment + " */ "
+}
+
+// recordMigration marks a migration as applied with the tracker. Skipped
+// migrations are recorded so they are not evaluated again.
+func (m *Migrator) recordMigration(ctx context.Context, tx *sql.Tx, mig *migration, skipped bool) error {
+	return m.markApplied(ctx, tx, Record{
+		Version:   mig.version,
+		Checksum:  mig.checksum,
+		AppliedBy: m.cfg.appliedBy,
+		Release:   mig.directives.release,
+		Path:      mig.file,
+		Skipped:   skipped,
+	})
+}
+
+// markApplied records a migration with the WithRecordFunc function, or the
+// tracker when none is set.
+func (m *Migrator) markApplied(ctx context.Context, tx *sql.Tx, record Record) error {
+	if m.cfg.recordFunc != nil {
+		return m.cfg.recordFunc(ctx, tx, record)
+	}
+	return m.tracker.MarkApplied(ctx, tx, record)
+}
+
+// versionOf returns the version recorded for a migration file.
+func versionOf(file string) string {
+	if strings.HasSuffix(file, ".sql") {
+		return strings.TrimSuffix(file, ".sql")
+	}
+	// Compressed files such as "001_create_users.sql.gz".
+	if i := strings.LastIndex(file, ".sql."); i >= 0 {
+		return file[:i]
+	}
+	return file
+}
@@ -4,11 +4,22 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"io/fs"
 	"log/slog"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -64,6 +75,23 @@ func openDB(t *testing.T) (*sql.DB, string, func()) {
 	}
 }
 
+// newFileMigrator creates a Migrator for tests that only read migration
+// files. The database handle is never connected.
+func newFileMigrator(t *testing.T, migrations fs.FS, opts ...Option) *Migrator {
+	t.Helper()
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, migrations, opts...)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	return m
+}
+
 func TestMigrator(t *testing.T) {
 	t.Run("creates migrations table", func(t *testing.T) {
 		db, schema, closeDB := openDB(t)
@@ -284,6 +312,26 @@ func TestNewValidation(t *testing.T) {
 			t.Fatal("expected error for nil migrations, got nil")
 		}
 	})
+
+	t.Run("invalid table name returns error", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		name := "schema_migrations; DROP TABLE test_table"
+		if _, err := New(db, testMigrationsFS(t), WithTableName(name)); err == nil {
+			t.Fatal("expected error for invalid table name, got nil")
+		}
+	})
+
+	t.Run("invalid session setting returns error", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		settings := map[string]string{"timezone = 'UTC'; DROP TABLE test_table; --": "UTC"}
+		if _, err := New(db, testMigrationsFS(t), WithSessionSettings(settings)); err == nil {
+			t.Fatal("expected error for invalid session setting, got nil")
+		}
+	})
 }
 
 func TestOptions(t *testing.T) {
@@ -342,6 +390,33 @@ func TestOptions(t *testing.T) {
 	})
 }
 
+func TestAppliedBy(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	const sha = "3f2c9e1"
+	m, err := New(db, testMigrationsFS(t), WithAppliedBy(sha))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+	for _, status := range statuses {
+		if status.AppliedBy != sha {
+			t.Fatalf("expected %s to be applied by %s, got %q", status.Version, sha, status.AppliedBy)
+		}
+	}
+}
+
 func TestRunWithCancelledContext(t *testing.T) {
 	db, _, closeDB := openDB(t)
 	defer closeDB()
@@ -358,3 +433,3405 @@ func TestRunWithCancelledContext(t *testing.T) {
 		t.Fatal("expected error for cancelled context, got nil")
 	}
 }
+
+func TestImportHistory(t *testing.T) {
+	t.Run("subsequent run applies only the remainder", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		// The imported migration was applied by another tool.
+		if _, err := db.Exec(`CREATE TABLE test_table (id SERIAL PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+			t.Fatalf("failed to create test_table: %v", err)
+		}
+
+		m, err := New(db, testMigrationsFS(t))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.ImportHistory(context.Background(), []string{"001_create_test_table"}); err != nil {
+			t.Fatalf("failed to import history: %v", err)
+		}
+		if err := m.ImportHistory(context.Background(), []string{"001_create_test_table"}); err != nil {
+			t.Fatalf("failed to import history twice: %v", err)
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+			t.Fatalf("failed to get applied migrations count: %v", err)
+		}
+		if count != 1 {
+			t.Fatalf("expected 1 imported migration, got %d", count)
+		}
+
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+			t.Fatalf("failed to get applied migrations count: %v", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 applied migrations, got %d", count)
+		}
+	})
+
+	t.Run("unknown version returns error", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, testMigrationsFS(t))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.ImportHistory(context.Background(), []string{"999_missing"}); err == nil {
+			t.Fatal("expected error for unknown version, got nil")
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+			t.Fatalf("failed to get applied migrations count: %v", err)
+		}
+		if count != 0 {
+			t.Fatalf("expected no imported migrations, got %d", count)
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	t.Run("reports pending before the migrations table exists", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, testMigrationsFS(t))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+
+		statuses, err := m.Status(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		if len(statuses) != 2 {
+			t.Fatalf("expected 2 statuses, got %d", len(statuses))
+		}
+		for _, status := range statuses {
+			if status.Applied {
+				t.Fatalf("expected %s to be pending", status.Version)
+			}
+		}
+	})
+
+	t.Run("reads from a read-only replica connection", func(t *testing.T) {
+		db, schema, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, testMigrationsFS(t))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+
+		replica, err := sql.Open("postgres", fmt.Sprintf("%s?sslmode=disable&search_path=%s&default_transaction_read_only=on", os.Getenv("DATABASE_URL"), schema))
+		if err != nil {
+			t.Fatalf("failed to open replica: %v", err)
+		}
+		defer replica.Close()
+
+		statuses, err := m.StatusWith(context.Background(), replica)
+		if err != nil {
+			t.Fatalf("failed to get status from replica: %v", err)
+		}
+
+		expectedVersions := []string{
+			"001_create_test_table",
+			"002_add_test_column",
+		}
+		if len(statuses) != len(expectedVersions) {
+			t.Fatalf("expected %d statuses, got %d", len(expectedVersions), len(statuses))
+		}
+		for i, status := range statuses {
+			if status.Version != expectedVersions[i] {
+				t.Fatalf("expected migration %s, got %s", expectedVersions[i], status.Version)
+			}
+			if !status.Applied {
+				t.Fatalf("expected %s to be applied", status.Version)
+			}
+			if status.AppliedAt.IsZero() {
+				t.Fatalf("expected applied_at for %s", status.Version)
+			}
+		}
+	})
+}
+
+func TestEnvironment(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_create_users.sql":    {Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT NOT NULL);`)},
+		"002_seed_fixtures.sql":   {Data: []byte("-- migrator:env dev,staging\nINSERT INTO users (name) VALUES ('fixture');")},
+		"003_add_users_email.sql": {Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT;`)},
+	}
+
+	tests := []struct {
+		env              string
+		expectedVersions []string
+		expectedUsers    int
+	}{
+		{
+			env:              "dev",
+			expectedVersions: []string{"001_create_users", "002_seed_fixtures", "003_add_users_email"},
+			expectedUsers:    1,
+		},
+		{
+			env:              "prod",
+			expectedVersions: []string{"001_create_users", "002_seed_fixtures (skipped)", "003_add_users_email"},
+			expectedUsers:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			db, _, closeDB := openDB(t)
+			defer closeDB()
+
+			m, err := New(db, migrations, WithEnvironment(tt.env))
+			if err != nil {
+				t.Fatalf("failed to create migrator: %v", err)
+			}
+			if err := m.Run(context.Background()); err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+
+			rows, err := db.Query("SELECT version, skipped FROM schema_migrations ORDER BY version")
+			if err != nil {
+				t.Fatalf("failed to get applied migrations: %v", err)
+			}
+			defer rows.Close()
+
+			var versions []string
+			for rows.Next() {
+				var version string
+				var skipped bool
+				if err := rows.Scan(&version, &skipped); err != nil {
+					t.Fatalf("failed to scan migration version: %v", err)
+				}
+				if skipped {
+					version += " (skipped)"
+				}
+				versions = append(versions, version)
+			}
+			if !slices.Equal(versions, tt.expectedVersions) {
+				t.Fatalf("expected versions %v, got %v", tt.expectedVersions, versions)
+			}
+
+			var users int
+			if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users); err != nil {
+				t.Fatalf("failed to count users: %v", err)
+			}
+			if users != tt.expectedUsers {
+				t.Fatalf("expected %d users, got %d", tt.expectedUsers, users)
+			}
+			if err := m.EnsureMigrated(context.Background()); err != nil {
+				t.Fatalf("expected no pending migrations, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDumpSchema(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	dump, err := m.DumpSchema(context.Background())
+	if err != nil {
+		t.Fatalf("failed to dump schema: %v", err)
+	}
+	for _, want := range []string{
+		"table test_table\n",
+		"  column id integer NOT NULL DEFAULT nextval('test_table_id_seq'::regclass)\n",
+		"  column test_column text\n",
+		"  constraint test_table_pkey PRIMARY KEY (id)\n",
+		"  index CREATE UNIQUE INDEX test_table_pkey ON test_table USING btree (id)\n",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Fatalf("expected dump to contain %q, got:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "schema_migrations") {
+		t.Fatalf("expected dump to exclude the migrations table, got:\n%s", dump)
+	}
+
+	again, err := m.DumpSchema(context.Background())
+	if err != nil {
+		t.Fatalf("failed to dump schema: %v", err)
+	}
+	if again != dump {
+		t.Fatalf("expected identical dumps, got:\n%s\nand:\n%s", dump, again)
+	}
+}
+
+func TestOnTableCreated(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var calls int
+	m, err := New(db, testMigrationsFS(t), WithOnTableCreated(func(ctx context.Context, tx *sql.Tx) error {
+		calls++
+		_, err := tx.ExecContext(ctx, `CREATE INDEX schema_migrations_applied_at_idx ON schema_migrations (applied_at)`)
+		return err
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected hook to fire once on first run, got %d calls", calls)
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected hook not to fire on second run, got %d calls", calls)
+	}
+}
+
+func TestEnsureMigrated(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	err = m.EnsureMigrated(context.Background())
+	if !errors.Is(err, ErrPendingMigrations) {
+		t.Fatalf("expected ErrPendingMigrations, got %v", err)
+	}
+	var pendingErr *PendingMigrationsError
+	if !errors.As(err, &pendingErr) {
+		t.Fatalf("expected PendingMigrationsError, got %T", err)
+	}
+	expected := []string{"001_create_test_table", "002_add_test_column"}
+	if !slices.Equal(pendingErr.Versions, expected) {
+		t.Fatalf("expected pending %v, got %v", expected, pendingErr.Versions)
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if err := m.EnsureMigrated(context.Background()); err != nil {
+		t.Fatalf("expected no pending migrations, got %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	crc := func(content []byte) string {
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE(content)), 10)
+	}
+	migrations := testMigrationsFS(t)
+	m, err := New(db, migrations, WithChecksum(crc))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, status := range statuses {
+		content, err := fs.ReadFile(migrations, status.Version+".sql")
+		if err != nil {
+			t.Fatalf("failed to read migration: %v", err)
+		}
+		if expected := crc(content); status.Checksum != expected {
+			t.Fatalf("expected checksum %s for %s, got %s", expected, status.Version, status.Checksum)
+		}
+	}
+}
+
+func TestLockStatus(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	const lockID = 424242
+	m, err := New(db, testMigrationsFS(t), WithLockID(lockID))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	held, _, err := m.LockStatus(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get lock status: %v", err)
+	}
+	if held {
+		t.Fatal("expected lock not to be held")
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire connection: %v", err)
+	}
+	defer conn.Close()
+
+	var pid int
+	if err := conn.QueryRowContext(context.Background(), `SELECT pg_backend_pid()`).Scan(&pid); err != nil {
+		t.Fatalf("failed to get backend pid: %v", err)
+	}
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		t.Fatalf("failed to acquire advisory lock: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	held, holderPID, err := m.LockStatus(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get lock status: %v", err)
+	}
+	if !held {
+		t.Fatal("expected lock to be held")
+	}
+	if holderPID != pid {
+		t.Fatalf("expected holder pid %d, got %d", pid, holderPID)
+	}
+}
+
+// unreadableFS wraps an fs.FS and fails to open one file.
+type unreadableFS struct {
+	fsys fs.FS
+	name string
+}
+
+func (u unreadableFS) Open(name string) (fs.File, error) {
+	if name == u.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return u.fsys.Open(name)
+}
+
+func TestUnreadableMigrationFiles(t *testing.T) {
+	migrations := unreadableFS{
+		fsys: fstest.MapFS{
+			"001_create_users.sql": {Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+			"002_broken_link.sql":  {Data: []byte(`SELECT 1;`)},
+		},
+		name: "002_broken_link.sql",
+	}
+
+	t.Run("error names the file", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, migrations)
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		err = m.Run(context.Background())
+		if err == nil {
+			t.Fatal("expected error, got nil")
+		}
+		if !strings.Contains(err.Error(), "002_broken_link.sql") {
+			t.Fatalf("expected error to name the file, got %v", err)
+		}
+	})
+
+	t.Run("skipped with option", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, migrations, WithSkipUnreadable(true))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+			t.Fatalf("failed to get applied migrations count: %v", err)
+		}
+		if count != 1 {
+			t.Fatalf("expected 1 applied migration, got %d", count)
+		}
+	})
+}
+
+func TestApplicationName(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var visible string
+	m, err := New(db, testMigrationsFS(t),
+		WithApplicationName("migrator-test"),
+		WithOnTableCreated(func(ctx context.Context, tx *sql.Tx) error {
+			return tx.QueryRowContext(ctx, `SELECT application_name FROM pg_stat_activity WHERE pid = pg_backend_pid()`).Scan(&visible)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	if visible != "migrator-test" {
+		t.Fatalf("expected application_name migrator-test in pg_stat_activity, got %q", visible)
+	}
+}
+
+func TestSkipIf(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": {Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"002_create_users_if_missing.sql": {Data: []byte("-- migrator:skip-if to_regclass('users') IS NOT NULL\n" +
+			"CREATE TABLE users (id SERIAL PRIMARY KEY);")},
+		"003_add_legacy_flag.sql": {Data: []byte("-- migrator:skip-if to_regclass('legacy_features') IS NOT NULL\n" +
+			"ALTER TABLE users ADD COLUMN legacy BOOLEAN;")},
+	}
+
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	expectedSkipped := map[string]bool{
+		"001_create_users":            false,
+		"002_create_users_if_missing": true,
+		"003_add_legacy_flag":         false,
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Fatalf("expected %s to be recorded", status.Version)
+		}
+		if status.Skipped != expectedSkipped[status.Version] {
+			t.Fatalf("expected %s skipped=%t, got %t", status.Version, expectedSkipped[status.Version], status.Skipped)
+		}
+	}
+
+	var exists bool
+	if err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT FROM information_schema.columns
+			WHERE table_name = 'users'
+			AND column_name = 'legacy'
+		);
+	`).Scan(&exists); err != nil {
+		t.Fatalf("failed to check if legacy column exists: %v", err)
+	}
+	if !exists {
+		t.Fatal("legacy column does not exist")
+	}
+}
+
+func TestSessionSettingsDoNotLeak(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// With a single connection the pool hands back the migration connection.
+	db.SetMaxOpenConns(1)
+
+	migrations := fstest.MapFS{
+		"001_set_timeout.sql": {Data: []byte(`SET statement_timeout = '1234ms'; CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var timeout, appName string
+	if err := db.QueryRow(`SHOW statement_timeout`).Scan(&timeout); err != nil {
+		t.Fatalf("failed to show statement_timeout: %v", err)
+	}
+	if timeout != "0" {
+		t.Fatalf("expected statement_timeout to be reset to 0, got %s", timeout)
+	}
+	if err := db.QueryRow(`SHOW application_name`).Scan(&appName); err != nil {
+		t.Fatalf("failed to show application_name: %v", err)
+	}
+	if appName == "migrator" {
+		t.Fatal("expected application_name to be reset")
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": {Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"002_seed_dump.sql":    {Data: []byte("INSERT INTO users DEFAULT VALUES;\n" + strings.Repeat("-- padding\n", 100))},
+	}
+	m, err := New(db, migrations, WithMaxFileSize(256))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	err = m.Run(context.Background())
+	if err == nil {
+		t.Fatal("expected error for oversized migration, got nil")
+	}
+	if !strings.Contains(err.Error(), "002_seed_dump.sql") || !strings.Contains(err.Error(), "exceeding the limit") {
+		t.Fatalf("expected size limit error naming the file, got %v", err)
+	}
+}
+
+// readTrackingFS wraps an fs.FS and records the largest single read from any
+// file it opens.
+type readTrackingFS struct {
+	fsys    fs.FS
+	mu      sync.Mutex
+	maxRead int
+}
+
+func (r *readTrackingFS) Open(name string) (fs.File, error) {
+	f, err := r.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &readTrackingFile{File: f, fsys: r}, nil
+}
+
+type readTrackingFile struct {
+	fs.File
+	fsys *readTrackingFS
+}
+
+func (f *readTrackingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.fsys.mu.Lock()
+	f.fsys.maxRead = max(f.fsys.maxRead, n)
+	f.fsys.mu.Unlock()
+	return n, err
+}
+
+func TestStreamLargeMigration(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var seed strings.Builder
+	seed.WriteString("CREATE TABLE events (id INT PRIMARY KEY, payload TEXT);\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&seed, "INSERT INTO events VALUES (%d, 'payload; with a semicolon');\n", i)
+	}
+	size := seed.Len()
+
+	migrations := &readTrackingFS{fsys: fstest.MapFS{
+		"001_seed_events.sql": {Data: []byte(seed.String())},
+	}}
+	m, err := New(db, migrations, WithStatementSplitting(true), WithStreamThreshold(1024))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil {
+		t.Fatalf("failed to count events: %v", err)
+	}
+	if count != 5000 {
+		t.Fatalf("expected 5000 events, got %d", count)
+	}
+	if migrations.maxRead >= size/4 {
+		t.Fatalf("expected bounded reads of a %d byte file, got a %d byte read", size, migrations.maxRead)
+	}
+}
+
+// memTracker is an in-memory Tracker.
+type memTracker struct {
+	records []Record
+}
+
+func (t *memTracker) AppliedVersions(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	var versions []string
+	for _, record := range t.records {
+		versions = append(versions, record.Version)
+	}
+	return versions, nil
+}
+
+func (t *memTracker) MarkApplied(ctx context.Context, tx *sql.Tx, record Record) error {
+	t.records = append(t.records, record)
+	return nil
+}
+
+func TestTracker(t *testing.T) {
+	db, schema, closeDB := openDB(t)
+	defer closeDB()
+
+	// The tracker already knows about 001, which was applied out of band.
+	if _, err := db.Exec(`CREATE TABLE test_table (id SERIAL PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("failed to create test_table: %v", err)
+	}
+	tracker := &memTracker{records: []Record{{Version: "001_create_test_table"}}}
+
+	m, err := New(db, testMigrationsFS(t), WithTracker(tracker))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	if len(tracker.records) != 2 {
+		t.Fatalf("expected 2 tracked migrations, got %d", len(tracker.records))
+	}
+	record := tracker.records[1]
+	if record.Version != "002_add_test_column" || record.Checksum == "" || record.Skipped {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	var exists bool
+	if err := db.QueryRow(fmt.Sprintf(`
+		SELECT EXISTS (
+			SELECT FROM pg_tables
+			WHERE schemaname = '%s'
+			AND tablename = 'schema_migrations'
+		);
+	`, schema)).Scan(&exists); err != nil {
+		t.Fatalf("failed to check if migrations table exists: %v", err)
+	}
+	if exists {
+		t.Fatal("expected no migrations table with a custom tracker")
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if len(tracker.records) != 2 {
+		t.Fatalf("expected no new tracked migrations, got %d", len(tracker.records))
+	}
+}
+
+func TestTableLockTimeout(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t), WithTableLockTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`LOCK TABLE schema_migrations IN ACCESS EXCLUSIVE MODE`); err != nil {
+		t.Fatalf("failed to lock migrations table: %v", err)
+	}
+
+	start := time.Now()
+	err = m.Run(context.Background())
+	if !errors.Is(err, ErrTableLocked) {
+		t.Fatalf("expected ErrTableLocked, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected Run to fail fast, took %s", elapsed)
+	}
+}
+
+func TestTableLockRetryRequiresTimeout(t *testing.T) {
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := New(db, testMigrationsFS(t), WithTableLockRetry(3, time.Second)); err == nil {
+		t.Fatal("expected an error for WithTableLockRetry without WithTableLockTimeout")
+	}
+}
+
+func TestTableLockRetry(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var logs strings.Builder
+	m, err := New(db, testMigrationsFS(t),
+		WithTableLockTimeout(100*time.Millisecond),
+		WithTableLockRetry(5, 50*time.Millisecond),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`LOCK TABLE schema_migrations IN ACCESS EXCLUSIVE MODE`); err != nil {
+		t.Fatalf("failed to lock migrations table: %v", err)
+	}
+	released := time.AfterFunc(300*time.Millisecond, func() { tx.Rollback() })
+	defer released.Stop()
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("expected Run to succeed once the lock was released, got %v", err)
+	}
+	if !strings.Contains(logs.String(), "retrying migrations table lock") {
+		t.Fatalf("expected a retry to be logged, got %s", logs.String())
+	}
+}
+
+func TestReportFile(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	m, err := New(db, testMigrationsFS(t), WithReportFile(path))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var report runReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	var versions []string
+	for _, entry := range report.Migrations {
+		if entry.Checksum == "" || entry.AppliedAt.IsZero() {
+			t.Errorf("incomplete report entry %+v", entry)
+		}
+		versions = append(versions, entry.Version)
+	}
+	expected := []string{"001_create_test_table", "002_add_test_column"}
+	if !slices.Equal(versions, expected) {
+		t.Fatalf("expected versions %v, got %v", expected, versions)
+	}
+	if report.Head != "002_add_test_column" {
+		t.Fatalf("expected head 002_add_test_column, got %q", report.Head)
+	}
+	if !report.Committed || report.Error != "" {
+		t.Fatalf("expected committed report without error, got %+v", report)
+	}
+}
+
+func TestMarkAllApplied(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// The schema is already at head, created outside the migrator.
+	if _, err := db.Exec(`CREATE TABLE test_table (id SERIAL PRIMARY KEY, name TEXT NOT NULL, test_column TEXT)`); err != nil {
+		t.Fatalf("failed to create existing schema: %v", err)
+	}
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.MarkAllApplied(context.Background()); err != nil {
+		t.Fatalf("failed to mark migrations applied: %v", err)
+	}
+	if err := m.MarkAllApplied(context.Background()); err != nil {
+		t.Fatalf("failed to mark migrations applied again: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Fatalf("expected %s to be marked applied", status.Version)
+		}
+	}
+
+	// Run would fail on CREATE TABLE test_table if it executed anything.
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("expected Run to be a no-op, got: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 applied migrations, got %d", count)
+	}
+}
+
+func TestSupersede(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql":    &fstest.MapFile{Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"002_add_email.sql":       &fstest.MapFile{Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT NOT NULL REFERENCES missing (id);`)},
+		"003_add_email_fixed.sql": &fstest.MapFile{Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT;`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("expected 002_add_email to fail, got nil")
+	}
+
+	if err := m.Supersede(context.Background(), "003_add_email_fixed", "002_add_email"); err == nil {
+		t.Fatal("expected error superseding with an earlier version, got nil")
+	}
+	if err := m.Supersede(context.Background(), "002_add_email", "003_add_email_fixed"); err != nil {
+		t.Fatalf("failed to supersede migration: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	want := map[string]bool{"001_create_users": false, "002_add_email": true, "003_add_email_fixed": false}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Fatalf("expected %s to be applied", status.Version)
+		}
+		if status.Skipped != want[status.Version] {
+			t.Fatalf("expected %s skipped=%v, got %v", status.Version, want[status.Version], status.Skipped)
+		}
+	}
+}
+
+func TestMarkAppliedOne(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.MarkAppliedOne(context.Background(), "002_add_test_column"); err != nil {
+		t.Fatalf("failed to mark migration applied: %v", err)
+	}
+	if err := m.MarkAppliedOne(context.Background(), "002_add_test_column"); err == nil {
+		t.Fatal("expected error marking an applied migration, got nil")
+	}
+	if err := m.MarkAppliedOne(context.Background(), "999_missing"); err == nil {
+		t.Fatal("expected error for unknown version, got nil")
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 applied migrations, got %d", count)
+	}
+
+	// 001 ran, but the marked 002 did not add its column.
+	var exists bool
+	if err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT FROM information_schema.columns
+			WHERE table_schema = current_schema()
+			AND table_name = 'test_table'
+			AND column_name = 'test_column'
+		)`).Scan(&exists); err != nil {
+		t.Fatalf("failed to check test_column: %v", err)
+	}
+	if exists {
+		t.Fatal("expected marked migration not to run")
+	}
+}
+
+type span struct {
+	name, parent string
+	attrs        []slog.Attr
+	ended        bool
+}
+
+type spanKey struct{}
+
+// fakeTracer records spans, tracking parents through the context.
+type fakeTracer struct {
+	spans []*span
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(error)) {
+	s := &span{name: name, attrs: attrs}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.parent = parent.name
+	}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), func(error) { s.ended = true }
+}
+
+func TestTracer(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	tracer := &fakeTracer{}
+	m, err := New(db, testMigrationsFS(t), WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	if len(tracer.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(tracer.spans))
+	}
+	if run := tracer.spans[0]; run.name != "migrator.run" || run.parent != "" || !run.ended {
+		t.Fatalf("unexpected run span %+v", run)
+	}
+	for i, version := range []string{"001_create_test_table", "002_add_test_column"} {
+		s := tracer.spans[i+1]
+		if s.name != "migrator.migration" || s.parent != "migrator.run" || !s.ended {
+			t.Fatalf("unexpected migration span %+v", s)
+		}
+		if len(s.attrs) != 1 || s.attrs[0].Key != "version" || s.attrs[0].Value.String() != version {
+			t.Fatalf("expected version attribute %s, got %v", version, s.attrs)
+		}
+	}
+}
+
+func TestRenamedMigration(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	before := fstest.MapFS{
+		"001_old.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE renamed (id INT);`)},
+	}
+	m, err := New(db, before)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	// Re-running the CREATE TABLE would fail, so success means no re-application.
+	after := fstest.MapFS{
+		"001_new.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE renamed (id INT);`)},
+	}
+	var logs strings.Builder
+	m, err = New(db, after, WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations after rename: %v", err)
+	}
+	if !strings.Contains(logs.String(), "migration renamed after it was applied") {
+		t.Fatalf("expected rename warning, got logs: %s", logs.String())
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 applied migration, got %d", count)
+	}
+
+	var version string
+	if err := db.QueryRow("SELECT version FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("failed to get recorded version: %v", err)
+	}
+	if version != "001_new" {
+		t.Fatalf("expected the rename to be recorded, got version %s", version)
+	}
+
+	logs.Reset()
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations again: %v", err)
+	}
+	if strings.Contains(logs.String(), "migration renamed after it was applied") {
+		t.Fatalf("expected the rename to be reported once, got logs: %s", logs.String())
+	}
+}
+
+func TestRenamedMigrationWithOtherContent(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, fstest.MapFS{
+		"001_a.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE a (id INT);`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	ctx := context.Background()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	// 001_b reuses the number of the deleted 001_a but is a new migration.
+	m, err = New(db, fstest.MapFS{
+		"001_b.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE b (id INT);`)},
+	})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch for a file with another checksum, got %v", err)
+	}
+
+	var version string
+	if err := db.QueryRow("SELECT version FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("failed to get recorded version: %v", err)
+	}
+	if version != "001_a" {
+		t.Fatalf("expected 001_a to stay recorded, got version %s", version)
+	}
+}
+
+func TestGenerateSQLRecordsRename(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	content := []byte(`CREATE TABLE renamed (id INT);`)
+	m, err := New(db, fstest.MapFS{"001_old.sql": &fstest.MapFile{Data: content}})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	m, err = New(db, fstest.MapFS{"001_new.sql": &fstest.MapFile{Data: content}})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	var b strings.Builder
+	if err := m.GenerateSQL(context.Background(), &b); err != nil {
+		t.Fatalf("failed to generate SQL: %v", err)
+	}
+	script := b.String()
+	if !strings.Contains(script, `SET version = '001_new' WHERE version = '001_old';`) {
+		t.Fatalf("expected the rename to be recorded, got:\n%s", script)
+	}
+	if strings.Contains(script, "CREATE TABLE renamed") {
+		t.Fatalf("expected the renamed migration not to run again, got:\n%s", script)
+	}
+}
+
+func TestLockTimeoutSetting(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 500 * time.Microsecond, want: "1ms"},
+		{d: time.Millisecond, want: "1ms"},
+		{d: 1500 * time.Microsecond, want: "2ms"},
+		{d: 5 * time.Second, want: "5000ms"},
+	}
+	for _, tt := range tests {
+		if got := lockTimeoutSetting(tt.d); got != tt.want {
+			t.Errorf("lockTimeoutSetting(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestWaitForLock(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// The slow migration keeps the lock held while the other instances start.
+	migrations := fstest.MapFS{
+		"001_slow.sql":   &fstest.MapFile{Data: []byte(`SELECT pg_sleep(0.5);`)},
+		"002_create.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE waited (id INT);`)},
+	}
+
+	const instances = 5
+	done := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		go func() {
+			m, err := New(db, migrations, WithWaitForLock(30*time.Second))
+			if err != nil {
+				done <- err
+				return
+			}
+			done <- m.Run(context.Background())
+		}()
+	}
+
+	for i := 0; i < instances; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("expected every instance to succeed, got %v", err)
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 applied migrations, got %d", count)
+	}
+}
+
+func TestRequireMigrations(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	empty := fstest.MapFS{}
+
+	m, err := New(db, empty, WithRequireMigrations(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("expected error for empty migrations, got nil")
+	}
+
+	m, err = New(db, empty)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("expected no-op run without the option, got %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("expected no applied migrations, got %d", count)
+	}
+}
+
+func TestTransactionLock(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	broken := fstest.MapFS{
+		"001_broken.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE;`)},
+	}
+	m, err := New(db, broken, WithTransactionLock(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("expected error for broken migration, got nil")
+	}
+
+	held, _, err := m.LockStatus(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get lock status: %v", err)
+	}
+	if held {
+		t.Fatal("expected transaction lock to be released after the failed run")
+	}
+
+	m, err = New(db, testMigrationsFS(t), WithTransactionLock(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 applied migrations, got %d", count)
+	}
+}
+
+func TestBackupDirective(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE accounts (id INT, legacy_id INT);`)},
+		"002_drop.sql": &fstest.MapFile{Data: []byte(`-- migrator:backup accounts
+ALTER TABLE accounts DROP COLUMN legacy_id;`)},
+	}
+
+	var backedUp []string
+	handler := func(ctx context.Context, tx *sql.Tx, table string) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT FROM information_schema.columns
+				WHERE table_schema = current_schema()
+				AND table_name = $1
+				AND column_name = 'legacy_id'
+			)`, table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			t.Errorf("expected backup of %s before the migration dropped legacy_id", table)
+		}
+		backedUp = append(backedUp, table)
+		return nil
+	}
+
+	m, err := New(db, migrations, WithBackupHandler(handler))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if !slices.Equal(backedUp, []string{"accounts"}) {
+		t.Fatalf("expected backup of accounts, got %v", backedUp)
+	}
+}
+
+func TestSkipPattern(t *testing.T) {
+	t.Run("skips matching migrations", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, testMigrationsFS(t), WithSkipPattern("002_*"))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+
+		var versions []string
+		rows, err := db.Query("SELECT version FROM schema_migrations ORDER BY version")
+		if err != nil {
+			t.Fatalf("failed to query applied migrations: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version string
+			if err := rows.Scan(&version); err != nil {
+				t.Fatalf("failed to scan version: %v", err)
+			}
+			versions = append(versions, version)
+		}
+		if !slices.Equal(versions, []string{"001_create_test_table"}) {
+			t.Fatalf("expected only 001 applied, got %v", versions)
+		}
+	})
+
+	t.Run("invalid pattern returns error", func(t *testing.T) {
+		db, err := sql.Open("postgres", "")
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		defer db.Close()
+
+		if _, err := New(db, testMigrationsFS(t), WithSkipPattern("[")); err == nil {
+			t.Fatal("expected error for invalid pattern, got nil")
+		}
+	})
+}
+
+func TestPreMigrationCheck(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var checked []string
+	check := func(ctx context.Context, tx *sql.Tx, version string) error {
+		checked = append(checked, version)
+		if version == "002_add_test_column" {
+			return errors.New("replication lag too high")
+		}
+		return nil
+	}
+
+	m, err := New(db, testMigrationsFS(t), WithPreMigrationCheck(check))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	err = m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "002_add_test_column") {
+		t.Fatalf("expected run to abort at 002_add_test_column, got %v", err)
+	}
+	if !slices.Equal(checked, []string{"001_create_test_table", "002_add_test_column"}) {
+		t.Fatalf("expected checks for 001 and 002, got %v", checked)
+	}
+
+	// All migrations share one transaction, so 001 was rolled back too.
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err == nil && count != 0 {
+		t.Fatalf("expected no applied migrations, got %d", count)
+	}
+}
+
+func TestIncompatibleMigrationsTable(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// The layout golang-migrate uses for its schema_migrations table.
+	if _, err := db.Exec(`CREATE TABLE schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)`); err != nil {
+		t.Fatalf("failed to create golang-migrate table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, dirty) VALUES (2, false)`); err != nil {
+		t.Fatalf("failed to insert golang-migrate version: %v", err)
+	}
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	err = m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "golang-migrate") {
+		t.Fatalf("expected golang-migrate incompatibility error, got %v", err)
+	}
+}
+
+func TestRowLevelLock(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_first.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE first (id INT);`)},
+	}
+	m, err := New(db, migrations, WithRowLevelLock(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	// Hold the second run open inside its transaction while Status reads.
+	holding := make(chan struct{})
+	release := make(chan struct{})
+	migrations["002_second.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE second (id INT);`)}
+	m, err = New(db, migrations, WithRowLevelLock(true), WithPreMigrationCheck(func(ctx context.Context, tx *sql.Tx, version string) error {
+		close(holding)
+		<-release
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(context.Background()) }()
+	<-holding
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	statuses, err := m.Status(ctx)
+	close(release)
+	if err != nil {
+		t.Fatalf("expected Status to succeed while migrating, got %v", err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || statuses[1].Applied {
+		t.Fatalf("unexpected statuses %+v", statuses)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+}
+
+func TestRunForTenant(t *testing.T) {
+	db, schema, closeDB := openDB(t)
+	defer closeDB()
+
+	tenants := []string{schema + "_tenant_a", schema + "_tenant_b"}
+	for _, tenant := range tenants {
+		if _, err := db.Exec("CREATE SCHEMA " + tenant); err != nil {
+			t.Fatalf("failed to create tenant schema: %v", err)
+		}
+		defer db.Exec("DROP SCHEMA " + tenant + " CASCADE")
+	}
+
+	// Functions outside the tenant schema stay on the search_path.
+	if _, err := db.Exec(`CREATE FUNCTION shared_default() RETURNS INT AS 'SELECT 1' LANGUAGE sql`); err != nil {
+		t.Fatalf("failed to create shared function: %v", err)
+	}
+
+	// The slow migration keeps each tenant's lock held while the other runs.
+	migrations := fstest.MapFS{
+		"001_slow.sql":   &fstest.MapFile{Data: []byte(`SELECT pg_sleep(0.5);`)},
+		"002_create.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE tenant_data (id INT DEFAULT shared_default());`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	done := make(chan error, len(tenants))
+	for _, tenant := range tenants {
+		go func() { done <- m.RunForTenant(context.Background(), tenant) }()
+	}
+	for range tenants {
+		if err := <-done; err != nil {
+			t.Errorf("expected no cross-tenant lock contention, got %v", err)
+		}
+	}
+
+	for _, tenant := range tenants {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + tenant + ".schema_migrations").Scan(&count); err != nil {
+			t.Fatalf("failed to get applied migrations count: %v", err)
+		}
+		if count != 2 {
+			t.Fatalf("expected 2 applied migrations in %s, got %d", tenant, count)
+		}
+		if _, err := db.Exec("SELECT id FROM " + tenant + ".tenant_data"); err != nil {
+			t.Fatalf("expected tenant_data in %s: %v", tenant, err)
+		}
+	}
+}
+
+func TestRequireAllAppliedFilesPresent(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_first.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE first (id INT);`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	delete(migrations, "001_first.sql")
+	migrations["002_second.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE second (id INT);`)}
+	m, err = New(db, migrations, WithRequireAllAppliedFilesPresent(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	err = m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "001_first") {
+		t.Fatalf("expected error naming 001_first, got %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected no new migrations applied, got %d recorded", count)
+	}
+}
+
+func TestMigrationParams(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_settings.sql": &fstest.MapFile{Data: []byte(`
+			CREATE TABLE settings (name TEXT PRIMARY KEY, value TEXT NOT NULL);
+			INSERT INTO settings (name, value) VALUES ('plan', $plan);`)},
+	}
+	injection := "free'); DROP TABLE settings; --"
+	m, err := New(db, migrations, WithStatementSplitting(true), WithMigrationParams(map[string]any{"plan": injection}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var value string
+	if err := db.QueryRow("SELECT value FROM settings WHERE name = 'plan'").Scan(&value); err != nil {
+		t.Fatalf("failed to read setting: %v", err)
+	}
+	if value != injection {
+		t.Fatalf("expected bound value %q, got %q", injection, value)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "001_first.sql"), []byte(`CREATE TABLE first (id INT);`), 0o644); err != nil {
+		t.Fatalf("failed to write migration: %v", err)
+	}
+
+	m, err := New(db, os.DirFS(dir))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Watch(ctx, 20*time.Millisecond) }()
+	defer func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled from Watch, got %v", err)
+		}
+	}()
+
+	waitForCount := func(expected int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var count int
+			err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+			if err == nil && count == expected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d applied migrations, got %d (%v)", expected, count, err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	waitForCount(1)
+	if err := os.WriteFile(filepath.Join(dir, "002_second.sql"), []byte(`CREATE TABLE second (id INT);`), 0o644); err != nil {
+		t.Fatalf("failed to write migration: %v", err)
+	}
+	waitForCount(2)
+
+	// A failed migration is retried once it is fixed in place.
+	broken := filepath.Join(dir, "003_third.sql")
+	if err := os.WriteFile(broken, []byte(`CREATE TABLE third (id INT) oops;`), 0o644); err != nil {
+		t.Fatalf("failed to write migration: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := os.WriteFile(broken, []byte(`CREATE TABLE third (id INT);`), 0o644); err != nil {
+		t.Fatalf("failed to write migration: %v", err)
+	}
+	waitForCount(3)
+}
+
+func TestWatchRejectsNonPositiveInterval(t *testing.T) {
+	m := newFileMigrator(t, fstest.MapFS{})
+	if err := m.Watch(context.Background(), 0); err == nil {
+		t.Fatal("expected an error for a zero interval")
+	}
+}
+
+func TestSQLErrorLine(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_broken.sql": &fstest.MapFile{Data: []byte("CREATE TABLE ok (id INT);\nINSERT INTO ok\n  VALUES (1) oops;\n")},
+	}
+	m, err := New(db, migrations, WithStatementSplitting(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	err = m.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "error at line 3, column 14") {
+		t.Fatalf("expected error at line 3, column 14, got %v", err)
+	}
+}
+
+func TestStatementTag(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		t.Run(fmt.Sprintf("splitting %v", split), func(t *testing.T) {
+			db, _, closeDB := openDB(t)
+			defer closeDB()
+
+			migrations := fstest.MapFS{
+				"001_tagged.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE executed AS SELECT current_query() AS query;`)},
+			}
+			m, err := New(db, migrations, WithStatementTag("migrator"), WithStatementSplitting(split))
+			if err != nil {
+				t.Fatalf("failed to create migrator: %v", err)
+			}
+			if err := m.Run(context.Background()); err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+
+			var query string
+			if err := db.QueryRow("SELECT query FROM executed").Scan(&query); err != nil {
+				t.Fatalf("failed to read executed query: %v", err)
+			}
+			if !strings.HasPrefix(query, "/* migrator: 001_tagged */ ") {
+				t.Fatalf("expected executed SQL to start with the version comment, got %q", query)
+			}
+		})
+	}
+}
+
+func TestLockStrategy(t *testing.T) {
+	for _, strategy := range []LockStrategy{TableLock, NoLock} {
+		t.Run(fmt.Sprintf("strategy %d", strategy), func(t *testing.T) {
+			db, _, closeDB := openDB(t)
+			defer closeDB()
+
+			// Hold the advisory lock elsewhere: the advisory strategy would
+			// fail, so success shows it was never requested.
+			conn, err := db.Conn(context.Background())
+			if err != nil {
+				t.Fatalf("failed to acquire connection: %v", err)
+			}
+			defer conn.Close()
+			if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, defaultConfig().lockID); err != nil {
+				t.Fatalf("failed to take advisory lock: %v", err)
+			}
+			defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, defaultConfig().lockID)
+
+			m, err := New(db, testMigrationsFS(t), WithLockStrategy(strategy))
+			if err != nil {
+				t.Fatalf("failed to create migrator: %v", err)
+			}
+			if err := m.Run(context.Background()); err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+
+			var count int
+			if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+				t.Fatalf("failed to get applied migrations count: %v", err)
+			}
+			if count != 2 {
+				t.Fatalf("expected 2 applied migrations, got %d", count)
+			}
+		})
+	}
+}
+
+func TestAssertSchema(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	golden := `table test_table
+  column id integer NOT NULL DEFAULT nextval('test_table_id_seq'::regclass)
+  column name text NOT NULL
+  column test_column text
+  constraint test_table_pkey PRIMARY KEY (id)
+  index CREATE UNIQUE INDEX test_table_pkey ON test_table USING btree (id)
+`
+	expected := fstest.MapFS{
+		"schema.golden":  &fstest.MapFile{Data: []byte(golden)},
+		"drifted.golden": &fstest.MapFile{Data: []byte(strings.Replace(golden, "column test_column text", "column test_column integer", 1))},
+	}
+
+	if err := m.AssertSchema(context.Background(), expected, "schema.golden"); err != nil {
+		t.Fatalf("expected matching schema, got %v", err)
+	}
+
+	err = m.AssertSchema(context.Background(), expected, "drifted.golden")
+	if err == nil {
+		t.Fatal("expected error for divergent schema, got nil")
+	}
+	for _, want := range []string{
+		"- table test_table: column test_column integer",
+		"+ table test_table: column test_column text",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected diff line %q, got %v", want, err)
+		}
+	}
+}
+
+func TestDeferConstraints(t *testing.T) {
+	schema := `
+		CREATE TABLE customers (id INT PRIMARY KEY);
+		CREATE TABLE orders (
+			id INT PRIMARY KEY,
+			customer_id INT REFERENCES customers (id) DEFERRABLE INITIALLY IMMEDIATE
+		);`
+	// The order is inserted before the customer it references.
+	inserts := `
+		INSERT INTO orders (id, customer_id) VALUES (1, 1);
+		INSERT INTO customers (id) VALUES (1);`
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{name: "with directive", body: "-- migrator:defer-constraints\n" + inserts},
+		{name: "without directive", body: inserts, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _, closeDB := openDB(t)
+			defer closeDB()
+
+			migrations := fstest.MapFS{
+				"001_schema.sql":  &fstest.MapFile{Data: []byte(schema)},
+				"002_inserts.sql": &fstest.MapFile{Data: []byte(tt.body)},
+			}
+			m, err := New(db, migrations, WithStatementSplitting(true))
+			if err != nil {
+				t.Fatalf("failed to create migrator: %v", err)
+			}
+			err = m.Run(context.Background())
+			if tt.wantErr && err == nil {
+				t.Fatal("expected foreign key violation, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("failed to run migrations: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeferConstraintsRestoresInitiallyDeferred(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_schema.sql": &fstest.MapFile{Data: []byte(`
+			CREATE TABLE customers (id INT PRIMARY KEY);
+			CREATE TABLE orders (
+				id INT PRIMARY KEY,
+				customer_id INT REFERENCES customers (id) DEFERRABLE INITIALLY DEFERRED
+			);`)},
+		"002_deferred.sql": &fstest.MapFile{Data: []byte("-- migrator:defer-constraints\nINSERT INTO customers (id) VALUES (1);")},
+		// Relies on the constraint still being deferred until commit.
+		"003_orders.sql": &fstest.MapFile{Data: []byte(`
+			INSERT INTO orders (id, customer_id) VALUES (2, 2);
+			INSERT INTO customers (id) VALUES (2);`)},
+	}
+	m, err := New(db, migrations, WithStatementSplitting(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("expected the INITIALLY DEFERRED constraint to stay deferred, got %v", err)
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	blue, _, closeBlue := openDB(t)
+	defer closeBlue()
+	green, _, closeGreen := openDB(t)
+	defer closeGreen()
+
+	source, err := New(blue, testMigrationsFS(t), WithAppliedBy("deploy-42"))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := source.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	data, err := source.ExportState(context.Background())
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+
+	target, err := New(green, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := target.ImportState(context.Background(), data); err != nil {
+			t.Fatalf("failed to import state (attempt %d): %v", i+1, err)
+		}
+	}
+
+	want, err := source.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get source status: %v", err)
+	}
+	got, err := target.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get target status: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d statuses, got %d", len(want), len(got))
+	}
+	for i := range want {
+		w, g := want[i], got[i]
+		if g.Version != w.Version || !g.Applied || g.AppliedBy != w.AppliedBy || g.Checksum != w.Checksum || !g.AppliedAt.Equal(w.AppliedAt) {
+			t.Fatalf("expected imported status %+v, got %+v", w, g)
+		}
+	}
+
+	// Nothing was run on the target: the table from 001 does not exist.
+	var exists bool
+	if err := green.QueryRow(`SELECT to_regclass('test_table') IS NOT NULL`).Scan(&exists); err != nil {
+		t.Fatalf("failed to check test_table: %v", err)
+	}
+	if exists {
+		t.Fatal("expected ImportState not to run migration SQL")
+	}
+}
+
+func TestStatementTiming(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_timed.sql": &fstest.MapFile{Data: []byte("SELECT pg_sleep(0.2);\nSELECT 1;")},
+	}
+	var logs strings.Builder
+	m, err := New(db, migrations,
+		WithStatementSplitting(true),
+		WithStatementTiming(true),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	type entry struct {
+		Msg       string        `json:"msg"`
+		Version   string        `json:"version"`
+		Statement int           `json:"statement"`
+		Duration  time.Duration `json:"duration"`
+		SQL       string        `json:"sql"`
+	}
+	var timed []entry
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("failed to decode log line %q: %v", line, err)
+		}
+		if e.Msg == "executed statement" {
+			timed = append(timed, e)
+		}
+	}
+
+	if len(timed) != 2 {
+		t.Fatalf("expected 2 timed statements, got %d: %s", len(timed), logs.String())
+	}
+	if timed[0].Statement != 1 || timed[0].SQL != "SELECT pg_sleep(0.2)" || timed[0].Duration < 200*time.Millisecond {
+		t.Fatalf("unexpected timing for the slow statement: %+v", timed[0])
+	}
+	if timed[1].Statement != 2 || timed[1].SQL != "SELECT 1" || timed[1].Version != "001_timed" {
+		t.Fatalf("unexpected timing for the fast statement: %+v", timed[1])
+	}
+}
+
+func TestRunWithManifest(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// By name the insert would run before its table exists.
+	migrations := fstest.MapFS{
+		"migrations.txt": &fstest.MapFile{Data: []byte("b_create.sql\na_insert.sql\n")},
+		"a_insert.sql":   &fstest.MapFile{Data: []byte("INSERT INTO manifest_test VALUES (1);")},
+		"b_create.sql":   &fstest.MapFile{Data: []byte("CREATE TABLE manifest_test (id INT);")},
+	}
+	m, err := New(db, migrations, WithManifest("migrations.txt"))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM manifest_test").Scan(&count); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 row, got %d", count)
+	}
+}
+
+func TestRunRetry(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// Sequences are not transactional, so the count survives the rollback
+	// and only the first attempt kills its own connection.
+	if _, err := db.Exec(`CREATE SEQUENCE attempts`); err != nil {
+		t.Fatalf("failed to create sequence: %v", err)
+	}
+	migrations := fstest.MapFS{
+		"001_flaky.sql": &fstest.MapFile{Data: []byte(`
+			SELECT CASE WHEN nextval('attempts') = 1 THEN pg_terminate_backend(pg_backend_pid()) END;
+			CREATE TABLE retried (id INT);`)},
+	}
+	m, err := New(db, migrations, WithRunRetry(2, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("expected run to succeed after retrying, got %v", err)
+	}
+
+	var attempts int
+	if err := db.QueryRow(`SELECT last_value FROM attempts`).Scan(&attempts); err != nil {
+		t.Fatalf("failed to read attempts: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	if _, err := db.Exec(`SELECT id FROM retried`); err != nil {
+		t.Fatalf("expected retried table to exist: %v", err)
+	}
+}
+
+func TestServerVersion(t *testing.T) {
+	t.Run("records the server version", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, testMigrationsFS(t), WithMinServerVersion("10"))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+
+		var expected string
+		if err := db.QueryRow(`SHOW server_version`).Scan(&expected); err != nil {
+			t.Fatalf("failed to get server version: %v", err)
+		}
+		statuses, err := m.Status(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		for _, status := range statuses {
+			if status.ServerVersion != expected {
+				t.Fatalf("expected server version %q for %s, got %q", expected, status.Version, status.ServerVersion)
+			}
+		}
+	})
+
+	t.Run("rejects an older server", func(t *testing.T) {
+		db, _, closeDB := openDB(t)
+		defer closeDB()
+
+		m, err := New(db, testMigrationsFS(t), WithMinServerVersion("999"))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.Run(context.Background()); err == nil {
+			t.Fatal("expected error for an older server, got nil")
+		}
+	})
+}
+
+func TestReleaseDirective(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_a.sql": &fstest.MapFile{Data: []byte("-- migrator:release 2024.1\nCREATE TABLE a (id INT);")},
+		"002_b.sql": &fstest.MapFile{Data: []byte("-- migrator:release 2024.1\nCREATE TABLE b (id INT);")},
+		"003_c.sql": &fstest.MapFile{Data: []byte("CREATE TABLE c (id INT);")},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	var releases []string
+	for _, status := range statuses {
+		releases = append(releases, status.Release)
+	}
+	if expected := []string{"2024.1", "2024.1", ""}; !slices.Equal(releases, expected) {
+		t.Fatalf("expected releases %q, got %q", expected, releases)
+	}
+}
+
+func TestSQLRedactor(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_seed.sql": &fstest.MapFile{Data: []byte(`
+			CREATE TABLE api_keys (key TEXT);
+			INSERT INTO api_keys VALUES ('sk_live_secret');`)},
+	}
+	var logs strings.Builder
+	m, err := New(db, migrations,
+		WithStatementSplitting(true),
+		WithStatementTiming(true),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithSQLRedactor(func(sql string) string {
+			return strings.ReplaceAll(sql, "sk_live_secret", "[REDACTED]")
+		}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	if strings.Contains(logs.String(), "sk_live_secret") {
+		t.Fatalf("expected secret to be redacted, got logs: %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "[REDACTED]") {
+		t.Fatalf("expected redacted statement in logs, got: %s", logs.String())
+	}
+}
+
+func TestRunSince(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"20240101000000_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"20240301000000_create_posts.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE posts (id SERIAL PRIMARY KEY);`)},
+		"20240401000000_create_tags.sql":  &fstest.MapFile{Data: []byte(`CREATE TABLE tags (id SERIAL PRIMARY KEY);`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.RunSince(context.Background(), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	want := map[string]bool{
+		"20240101000000_create_users": false,
+		"20240301000000_create_posts": true,
+		"20240401000000_create_tags":  true,
+	}
+	for _, status := range statuses {
+		if status.Applied != want[status.Version] {
+			t.Fatalf("expected %s applied=%v, got %v", status.Version, want[status.Version], status.Applied)
+		}
+	}
+}
+
+func TestSessionSettings(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// A single connection keeps the session timezone for the migration run.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`SET timezone TO 'America/New_York'`); err != nil {
+		t.Fatalf("failed to set session timezone: %v", err)
+	}
+	migrations := fstest.MapFS{
+		"001_seed.sql": &fstest.MapFile{Data: []byte(`
+			CREATE TABLE events (at TEXT);
+			INSERT INTO events VALUES ('2024-01-01 12:00:00+00'::timestamptz::text);`)},
+	}
+	m, err := New(db, migrations, WithSessionSettings(map[string]string{"timezone": "UTC"}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var at string
+	if err := db.QueryRow(`SELECT at FROM events`).Scan(&at); err != nil {
+		t.Fatalf("failed to query events: %v", err)
+	}
+	if at != "2024-01-01 12:00:00+00" {
+		t.Fatalf("expected timestamp in UTC, got %q", at)
+	}
+}
+
+func TestDetectGaps(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"003_create_posts.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE posts (id SERIAL PRIMARY KEY);`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	gaps, err := m.DetectGaps(context.Background())
+	if err != nil {
+		t.Fatalf("failed to detect gaps: %v", err)
+	}
+	if len(gaps) != 1 || gaps[0] != "002" {
+		t.Fatalf("expected gap [002], got %v", gaps)
+	}
+}
+
+func TestSlowMigrationThreshold(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_fast.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"002_slow.sql": &fstest.MapFile{Data: []byte(`SELECT pg_sleep(0.2);`)},
+	}
+	var logs strings.Builder
+	m, err := New(db, migrations,
+		WithSlowMigrationThreshold(100*time.Millisecond),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var slow []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Msg     string `json:"msg"`
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to parse log line %q: %v", line, err)
+		}
+		if entry.Msg == "slow migration" {
+			slow = append(slow, entry.Version)
+		}
+	}
+	if len(slow) != 1 || slow[0] != "002_slow" {
+		t.Fatalf("expected a slow warning for 002_slow only, got %v", slow)
+	}
+}
+
+func TestCreateTempDatabase(t *testing.T) {
+	adminDB, err := sql.Open("postgres", os.Getenv("DATABASE_URL")+"?sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer adminDB.Close()
+
+	var name string
+	db, cleanup, err := CreateTempDatabase(context.Background(), adminDB, "migrator_test", func(dbname string) (*sql.DB, error) {
+		name = dbname
+		u, err := url.Parse(os.Getenv("DATABASE_URL"))
+		if err != nil {
+			return nil, err
+		}
+		u.Path = "/" + dbname
+		return sql.Open("postgres", u.String()+"?sslmode=disable")
+	})
+	if err != nil {
+		t.Fatalf("failed to create temp database: %v", err)
+	}
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if err := m.EnsureMigrated(context.Background()); err != nil {
+		t.Fatalf("expected temp database to be migrated: %v", err)
+	}
+
+	if err := cleanup(); err != nil {
+		t.Fatalf("failed to clean up temp database: %v", err)
+	}
+	var exists bool
+	if err := adminDB.QueryRow(`SELECT EXISTS (SELECT FROM pg_database WHERE datname = $1)`, name).Scan(&exists); err != nil {
+		t.Fatalf("failed to check database: %v", err)
+	}
+	if exists {
+		t.Fatalf("expected database %s to be dropped", name)
+	}
+}
+
+func TestIsApplied(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.MarkAppliedOne(context.Background(), "001_create_test_table"); err != nil {
+		t.Fatalf("failed to mark migration applied: %v", err)
+	}
+
+	for version, want := range map[string]bool{
+		"001_create_test_table": true,
+		"002_add_test_column":   false,
+		"999_missing":           false,
+	} {
+		got, err := m.IsApplied(context.Background(), version)
+		if err != nil {
+			t.Fatalf("failed to check %s: %v", version, err)
+		}
+		if got != want {
+			t.Fatalf("IsApplied(%s) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestIsAppliedSkipped(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+		"002_seed_users.sql":   &fstest.MapFile{Data: []byte("-- migrator:skip-if true\nINSERT INTO users VALUES (1);")},
+	})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	for version, want := range map[string]bool{
+		"001_create_users": true,
+		"002_seed_users":   false,
+	} {
+		got, err := m.IsApplied(context.Background(), version)
+		if err != nil {
+			t.Fatalf("failed to check %s: %v", version, err)
+		}
+		if got != want {
+			t.Fatalf("IsApplied(%s) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestPostMigrationSQL(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	if _, err := db.Exec(`CREATE TABLE schema_version (n INT NOT NULL); INSERT INTO schema_version VALUES (0)`); err != nil {
+		t.Fatalf("failed to create version table: %v", err)
+	}
+	post := WithPostMigrationSQL([]string{`UPDATE schema_version SET n = n + 1`})
+	version := func() int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(`SELECT n FROM schema_version`).Scan(&n); err != nil {
+			t.Fatalf("failed to query version table: %v", err)
+		}
+		return n
+	}
+
+	failing := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"002_broken.sql":       &fstest.MapFile{Data: []byte(`SELECT * FROM missing_table;`)},
+	}
+	m, err := New(db, failing, post)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("expected migration error, got nil")
+	}
+	if n := version(); n != 0 {
+		t.Fatalf("expected post-migration update to roll back, got version %d", n)
+	}
+
+	m, err = New(db, testMigrationsFS(t), post)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	for range 2 {
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+	}
+	if n := version(); n != 1 {
+		t.Fatalf("expected post-migration update to run once with the migrations, got version %d", n)
+	}
+
+	// Recording a migration of another environment as skipped applies nothing.
+	m, err = New(db, fstest.MapFS{
+		"900_dev_seed.sql": &fstest.MapFile{Data: []byte("-- migrator:env dev\nSELECT 1;")},
+	}, post, WithEnvironment("prod"))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if n := version(); n != 1 {
+		t.Fatalf("expected no post-migration update for a skipped migration, got version %d", n)
+	}
+}
+
+func TestPauseDirective(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id SERIAL PRIMARY KEY);`)},
+		"002_add_email.sql":    &fstest.MapFile{Data: []byte("-- migrator:pause\nALTER TABLE users ADD COLUMN email TEXT;")},
+		"003_add_name.sql":     &fstest.MapFile{Data: []byte(`ALTER TABLE users ADD COLUMN name TEXT;`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	err = m.Run(context.Background())
+	var paused *PausedError
+	if !errors.As(err, &paused) || !errors.Is(err, ErrPaused) {
+		t.Fatalf("expected PausedError, got %v", err)
+	}
+	if paused.Version != "002_add_email" {
+		t.Fatalf("expected pause after 002_add_email, got %s", paused.Version)
+	}
+	for version, want := range map[string]bool{"001_create_users": true, "002_add_email": true, "003_add_name": false} {
+		got, err := m.IsApplied(context.Background(), version)
+		if err != nil {
+			t.Fatalf("failed to check %s: %v", version, err)
+		}
+		if got != want {
+			t.Fatalf("expected %s applied=%v after pause, got %v", version, want, got)
+		}
+	}
+
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to resume migrations: %v", err)
+	}
+	if err := m.EnsureMigrated(context.Background()); err != nil {
+		t.Fatalf("expected all migrations applied after resuming: %v", err)
+	}
+}
+
+func TestRewriteWarnings(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+		"002_add_email.sql":    &fstest.MapFile{Data: []byte(`ALTER TABLE users ADD COLUMN email TEXT;`)},
+		"003_widen_id.sql":     &fstest.MapFile{Data: []byte(`ALTER TABLE users ALTER COLUMN id SET DATA TYPE BIGINT;`)},
+	}
+	var logs strings.Builder
+	m, err := New(db, migrations,
+		WithRewriteWarnings(true),
+		WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var warned []string
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Msg     string `json:"msg"`
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to parse log line %q: %v", line, err)
+		}
+		if entry.Msg == "statement may rewrite the table" {
+			warned = append(warned, entry.Version)
+		}
+	}
+	if len(warned) != 1 || warned[0] != "003_widen_id" {
+		t.Fatalf("expected a rewrite warning for 003_widen_id only, got %v", warned)
+	}
+}
+
+func TestRunDeadlinePhase(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, defaultConfig().lockID); err != nil {
+		t.Fatalf("failed to take advisory lock: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, defaultConfig().lockID)
+
+	m, err := New(db, testMigrationsFS(t), WithWaitForLock(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = m.Run(ctx)
+	var deadlineErr *DeadlineError
+	if !errors.As(err, &deadlineErr) {
+		t.Fatalf("expected DeadlineError, got %v", err)
+	}
+	if deadlineErr.Phase != PhaseLock {
+		t.Fatalf("expected deadline in phase %q, got %q", PhaseLock, deadlineErr.Phase)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected error to match context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestDistinctLockIDsRunConcurrently(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	const sleep = 500 * time.Millisecond
+	sets := []struct {
+		lockID int64
+		table  string
+		fsys   fs.FS
+	}{
+		{lockID: 1, table: "billing_migrations", fsys: fstest.MapFS{
+			"001_create_invoices.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE invoices (id INT); SELECT pg_sleep(0.5);`)},
+		}},
+		{lockID: 2, table: "catalog_migrations", fsys: fstest.MapFS{
+			"001_create_products.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE products (id INT); SELECT pg_sleep(0.5);`)},
+		}},
+	}
+
+	start := time.Now()
+	done := make(chan error, len(sets))
+	for _, set := range sets {
+		m, err := New(db, set.fsys, WithLockID(set.lockID), WithTableName(set.table))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		go func() { done <- m.Run(context.Background()) }()
+	}
+	for range sets {
+		if err := <-done; err != nil {
+			t.Fatalf("failed to run migrations: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed >= 2*sleep {
+		t.Fatalf("expected migration sets to run concurrently in under %v, took %v", 2*sleep, elapsed)
+	}
+}
+
+func TestRunWithDecompressor(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// A made-up "compression" that stores the SQL reversed.
+	reverse := func(s string) string {
+		b := []byte(s)
+		slices.Reverse(b)
+		return string(b)
+	}
+	migrations := fstest.MapFS{
+		"001_create_users.sql.rev": &fstest.MapFile{Data: []byte(reverse(`CREATE TABLE users (id SERIAL PRIMARY KEY);`))},
+	}
+	m, err := New(db, migrations, WithDecompressor(".rev", func(r io.Reader) (io.Reader, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(reverse(string(data))), nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO users DEFAULT VALUES`); err != nil {
+		t.Fatalf("expected users table from the decompressed migration: %v", err)
+	}
+	applied, err := m.IsApplied(context.Background(), "001_create_users")
+	if err != nil {
+		t.Fatalf("failed to check migration: %v", err)
+	}
+	if !applied {
+		t.Fatal("expected 001_create_users to be recorded without the compression extension")
+	}
+}
+
+func TestAppLockDirective(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	const appLockID = 1001
+	migrations := fstest.MapFS{
+		"001_create_jobs.sql": &fstest.MapFile{Data: []byte(fmt.Sprintf("-- migrator:app-lock %d\nCREATE TABLE jobs (id INT);", appLockID))},
+	}
+
+	// The application holds the lock, e.g. while a worker runs.
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to acquire connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_lock($1)`, appLockID); err != nil {
+		t.Fatalf("failed to take application lock: %v", err)
+	}
+
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "application lock 1001 is held") {
+		t.Fatalf("expected held application lock error, got %v", err)
+	}
+
+	waiting, err := New(db, migrations, WithWaitForLock(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := waiting.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "still held after") {
+		t.Fatalf("expected application lock wait to time out, got %v", err)
+	}
+
+	if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, appLockID); err != nil {
+		t.Fatalf("failed to release application lock: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+}
+
+func TestTableSizeDelta(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	if _, err := db.Exec(`CREATE TABLE events (id INT, payload TEXT)`); err != nil {
+		t.Fatalf("failed to create events table: %v", err)
+	}
+	migrations := fstest.MapFS{
+		"001_seed_events.sql": &fstest.MapFile{Data: []byte(`INSERT INTO events SELECT n, repeat('x', 100) FROM generate_series(1, 1000) AS n;`)},
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	m, err := New(db, migrations, WithTableSizeDelta([]string{"events"}), WithReportFile(path))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var report runReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+	if len(report.TableSizes) != 1 || report.TableSizes[0].Table != "events" {
+		t.Fatalf("expected a size entry for events, got %+v", report.TableSizes)
+	}
+	if size := report.TableSizes[0]; size.Delta <= 0 || size.After != size.Before+size.Delta {
+		t.Fatalf("expected a positive size delta, got %+v", size)
+	}
+}
+
+func TestRunType(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql":   &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT, email TEXT, email_lower TEXT);`)},
+		"002_seed_users.sql":     &fstest.MapFile{Data: []byte("-- migrator:type data\nINSERT INTO users VALUES (1, 'A@example.com');")},
+		"003_create_posts.sql":   &fstest.MapFile{Data: []byte(`CREATE TABLE posts (id INT);`)},
+		"004_backfill_lower.sql": &fstest.MapFile{Data: []byte("-- migrator:type data\nUPDATE users SET email_lower = lower(email);")},
+	}
+	applied := func(m *Migrator) []string {
+		t.Helper()
+		statuses, err := m.Status(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get status: %v", err)
+		}
+		var versions []string
+		for _, status := range statuses {
+			if status.Applied {
+				versions = append(versions, status.Version)
+			}
+		}
+		return versions
+	}
+
+	schema, err := New(db, migrations, WithRunType(RunSchema))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := schema.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run schema migrations: %v", err)
+	}
+	if got, want := applied(schema), []string{"001_create_users", "003_create_posts"}; !slices.Equal(got, want) {
+		t.Fatalf("expected schema migrations %v applied, got %v", want, got)
+	}
+
+	data, err := New(db, migrations, WithRunType(RunData))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := data.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run data migrations: %v", err)
+	}
+	if err := data.EnsureMigrated(context.Background()); err != nil {
+		t.Fatalf("expected all migrations applied: %v", err)
+	}
+
+	// The backfill ran after the seed it depends on.
+	var lower string
+	if err := db.QueryRow(`SELECT email_lower FROM users WHERE id = 1`).Scan(&lower); err != nil {
+		t.Fatalf("failed to query users: %v", err)
+	}
+	if lower != "a@example.com" {
+		t.Fatalf("expected backfilled email_lower, got %q", lower)
+	}
+}
+
+// countingFS counts the migration files opened from fsys. It exposes only
+// Open, so every read of a file goes through the count.
+type countingFS struct {
+	fsys  fs.FS
+	opens int
+}
+
+func (f *countingFS) Open(name string) (fs.File, error) {
+	if name != "." {
+		f.opens++
+	}
+	return f.fsys.Open(name)
+}
+
+func TestRunReadsOnlyPendingFiles(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	fsys := &countingFS{fsys: fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+		"002_create_posts.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE posts (id INT);`)},
+	}}
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	fsys.opens = 0
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if err := m.EnsureMigrated(context.Background()); err != nil {
+		t.Fatalf("expected migrations applied: %v", err)
+	}
+	if fsys.opens != 0 {
+		t.Fatalf("expected no migration file reads on an up-to-date database, got %d", fsys.opens)
+	}
+
+	// WithSkipUnreadable opens every file to find the unreadable ones.
+	m, err = New(db, fsys, WithSkipUnreadable(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	fsys.opens = 0
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if fsys.opens != 2 {
+		t.Fatalf("expected WithSkipUnreadable to open each of the 2 files, got %d opens", fsys.opens)
+	}
+}
+
+func TestAuditSink(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	audited := make(map[string]string)
+	var versions []string
+	m, err := New(db, testMigrationsFS(t), WithAuditSink(func(version, sql string) {
+		versions = append(versions, version)
+		audited[version] = sql
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	if want := []string{"001_create_test_table", "002_add_test_column"}; !slices.Equal(versions, want) {
+		t.Fatalf("expected audited versions %v, got %v", want, versions)
+	}
+	if !strings.Contains(audited["001_create_test_table"], "CREATE TABLE test_table") {
+		t.Fatalf("expected 001 SQL to be audited, got %q", audited["001_create_test_table"])
+	}
+	if !strings.Contains(audited["002_add_test_column"], "ADD COLUMN test_column TEXT") {
+		t.Fatalf("expected 002 SQL to be audited, got %q", audited["002_add_test_column"])
+	}
+}
+
+func TestAuditSinkSkipsRolledBackRuns(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+		"002_broken.sql":       &fstest.MapFile{Data: []byte(`SELECT * FROM missing_table;`)},
+	}
+	calls := 0
+	m, err := New(db, migrations, WithAuditSink(func(string, string) { calls++ }))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("expected migration error, got nil")
+	}
+	if calls != 0 {
+		t.Fatalf("expected no audit of a rolled back run, got %d calls", calls)
+	}
+}
+
+func TestRecordFunc(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var recorded []string
+	m, err := New(db, testMigrationsFS(t), WithRecordFunc(func(ctx context.Context, tx *sql.Tx, record Record) error {
+		recorded = append(recorded, record.Version)
+		_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, checksum) VALUES ($1, $2) ON CONFLICT DO NOTHING`, record.Version, record.Checksum)
+		return err
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if want := []string{"001_create_test_table", "002_add_test_column"}; !slices.Equal(recorded, want) {
+		t.Fatalf("expected record func to record %v, got %v", want, recorded)
+	}
+
+	// Recording an applied version again is a no-op instead of a
+	// primary key violation.
+	err = m.withLockedTx(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+		mig, err := m.loadMigration("001_create_test_table.sql")
+		if err != nil {
+			return err
+		}
+		return m.recordMigration(ctx, tx, mig, false)
+	})
+	if err != nil {
+		t.Fatalf("expected re-recording to succeed, got %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count); err != nil {
+		t.Fatalf("failed to get applied migrations count: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 applied migrations, got %d", count)
+	}
+}
+
+func TestVerifyAgainst(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+	shadowDB, _, closeShadow := openDB(t)
+	defer closeShadow()
+
+	// The shadow is a clone of production with data the migration trips on.
+	for _, conn := range []*sql.DB{db, shadowDB} {
+		if _, err := conn.Exec(`CREATE TABLE users (id INT, email TEXT)`); err != nil {
+			t.Fatalf("failed to create users table: %v", err)
+		}
+	}
+	if _, err := shadowDB.Exec(`INSERT INTO users VALUES (1, 'a@example.com'), (2, 'a@example.com')`); err != nil {
+		t.Fatalf("failed to seed shadow users: %v", err)
+	}
+
+	migrations := fstest.MapFS{
+		"001_unique_email.sql": &fstest.MapFile{Data: []byte(`CREATE UNIQUE INDEX users_email ON users (email);`)},
+	}
+	m, err := New(db, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.VerifyAgainst(context.Background(), shadowDB); err == nil {
+		t.Fatal("expected the shadow run to fail on duplicate emails, got nil")
+	}
+
+	// Neither database recorded the migration.
+	for name, conn := range map[string]*sql.DB{"production": db, "shadow": shadowDB} {
+		var exists bool
+		if err := conn.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+			t.Fatalf("failed to check %s migrations table: %v", name, err)
+		}
+		if exists {
+			t.Fatalf("expected no migrations table in the %s schema", name)
+		}
+	}
+
+	if _, err := shadowDB.Exec(`DELETE FROM users WHERE id = 2`); err != nil {
+		t.Fatalf("failed to fix shadow users: %v", err)
+	}
+	if err := m.VerifyAgainst(context.Background(), shadowDB); err != nil {
+		t.Fatalf("expected the shadow run to pass, got %v", err)
+	}
+	if err := m.EnsureMigrated(context.Background()); !errors.Is(err, ErrPendingMigrations) {
+		t.Fatalf("expected production to remain unmigrated, got %v", err)
+	}
+}
+
+func TestTimestampTZ(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// A single connection keeps the non-UTC session timezone for every query.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`SET timezone TO 'Asia/Tokyo'`); err != nil {
+		t.Fatalf("failed to set session timezone: %v", err)
+	}
+
+	// An existing table with a TIMESTAMP column is converted.
+	legacy, err := New(db, fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := legacy.Run(context.Background()); err != nil {
+		t.Fatalf("failed to create migrations table: %v", err)
+	}
+
+	m, err := New(db, testMigrationsFS(t), WithTimestampTZ(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	start := time.Now()
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var typ string
+	if err := db.QueryRow(`SELECT format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = to_regclass('schema_migrations') AND attname = 'applied_at'`).Scan(&typ); err != nil {
+		t.Fatalf("failed to inspect applied_at: %v", err)
+	}
+	if typ != "timestamp with time zone" {
+		t.Fatalf("expected applied_at to be TIMESTAMPTZ, got %s", typ)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt.Location() != time.UTC {
+			t.Fatalf("expected %s applied_at in UTC, got %v", status.Version, status.AppliedAt.Location())
+		}
+		if d := status.AppliedAt.Sub(start); d < -time.Minute || d > time.Minute {
+			t.Fatalf("expected %s applied_at near %v, got %v", status.Version, start.UTC(), status.AppliedAt)
+		}
+	}
+}
+
+func TestConcurrentRecordRollsBackDoubleApply(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_counters.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE counters (n INT); INSERT INTO counters VALUES (0);`)},
+	}
+	empty, err := New(db, fstest.MapFS{})
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := empty.Run(context.Background()); err != nil {
+		t.Fatalf("failed to create migrations table: %v", err)
+	}
+
+	// Without locking, another process records the version while this run
+	// is applying it, as a second deploy racing this one would.
+	m, err := New(db, migrations, WithLockStrategy(NoLock), WithPreMigrationCheck(func(ctx context.Context, tx *sql.Tx, version string) error {
+		_, err := db.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+		return err
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err == nil {
+		t.Fatal("expected the conflicting record to fail the run, got nil")
+	}
+
+	// The run's SQL rolled back with its record, so nothing was applied twice.
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = '001_create_counters'`).Scan(&count); err != nil {
+		t.Fatalf("failed to count records: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly one record, got %d", count)
+	}
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass('counters') IS NOT NULL`).Scan(&exists); err != nil {
+		t.Fatalf("failed to check counters table: %v", err)
+	}
+	if exists {
+		t.Fatal("expected the run's SQL to roll back")
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	var prompts []string
+	answer := false
+	m, err := New(db, testMigrationsFS(t), WithConfirm(func(ctx context.Context, prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return answer, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	if err := m.Run(context.Background()); !errors.Is(err, ErrNotConfirmed) {
+		t.Fatalf("expected ErrNotConfirmed, got %v", err)
+	}
+	if err := m.EnsureMigrated(context.Background()); !errors.Is(err, ErrPendingMigrations) {
+		t.Fatalf("expected nothing applied after declining, got %v", err)
+	}
+
+	answer = true
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	if len(prompts) != 2 || !strings.Contains(prompts[0], "001_create_test_table, 002_add_test_column") {
+		t.Fatalf("expected one prompt per run with pending migrations, got %q", prompts)
+	}
+}
+
+func TestConfirmStopsAtPauseAndSkipsShadowRuns(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+	shadow, _, closeShadow := openDB(t)
+	defer closeShadow()
+
+	var prompts []string
+	m, err := New(db, fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("-- migrator:pause\nCREATE TABLE users (id INT);")},
+		"002_create_posts.sql": &fstest.MapFile{Data: []byte("CREATE TABLE posts (id INT);")},
+	}, WithConfirm(func(ctx context.Context, prompt string) (bool, error) {
+		prompts = append(prompts, prompt)
+		return true, nil
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	if err := m.VerifyAgainst(context.Background(), shadow); err != nil {
+		t.Fatalf("failed to verify against shadow database: %v", err)
+	}
+	if len(prompts) != 0 {
+		t.Fatalf("expected no prompt for a shadow run, got %q", prompts)
+	}
+
+	if err := m.Run(context.Background()); !errors.Is(err, ErrPaused) {
+		t.Fatalf("expected ErrPaused, got %v", err)
+	}
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "Apply 1 migrations (001_create_users)") {
+		t.Fatalf("expected the prompt to stop at the pause, got %q", prompts)
+	}
+}
+
+func TestCompareDatabases(t *testing.T) {
+	staging, _, closeStaging := openDB(t)
+	defer closeStaging()
+	production, _, closeProduction := openDB(t)
+	defer closeProduction()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+		"002_create_posts.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE posts (id INT);`)},
+		"003_create_tags.sql":  &fstest.MapFile{Data: []byte(`CREATE TABLE tags (id INT);`)},
+	}
+	for db, versions := range map[*sql.DB][]string{
+		staging:    {"001_create_users", "002_create_posts", "003_create_tags"},
+		production: {"001_create_users"},
+	} {
+		m, err := New(db, migrations)
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		if err := m.ImportHistory(context.Background(), versions); err != nil {
+			t.Fatalf("failed to import history: %v", err)
+		}
+	}
+	if _, err := production.Exec(`INSERT INTO schema_migrations (version) VALUES ('000_hotfix')`); err != nil {
+		t.Fatalf("failed to record hotfix: %v", err)
+	}
+	// A migration skipped on production has not run there.
+	if _, err := production.Exec(`INSERT INTO schema_migrations (version, skipped) VALUES ('002_create_posts', TRUE)`); err != nil {
+		t.Fatalf("failed to record skipped migration: %v", err)
+	}
+
+	m, err := New(staging, migrations)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	diff, err := m.CompareDatabases(context.Background(), staging, production)
+	if err != nil {
+		t.Fatalf("failed to compare databases: %v", err)
+	}
+	if want := []string{"002_create_posts", "003_create_tags"}; !slices.Equal(diff.OnlyA, want) {
+		t.Fatalf("expected only on staging %v, got %v", want, diff.OnlyA)
+	}
+	if want := []string{"000_hotfix"}; !slices.Equal(diff.OnlyB, want) {
+		t.Fatalf("expected only on production %v, got %v", want, diff.OnlyB)
+	}
+	if want := []string{"001_create_users"}; !slices.Equal(diff.Common, want) {
+		t.Fatalf("expected common %v, got %v", want, diff.Common)
+	}
+}
+
+func TestBackfillTable(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	if _, err := db.Exec(`
+		CREATE TABLE users (id INT PRIMARY KEY, email TEXT, email_lower TEXT);
+		INSERT INTO users (id, email) SELECT n, 'User' || n || '@Example.com' FROM generate_series(1, 250) AS n;`); err != nil {
+		t.Fatalf("failed to seed users: %v", err)
+	}
+
+	var progress []int
+	err := Backfill(context.Background(), db, `
+		UPDATE users SET email_lower = lower(email)
+		WHERE id IN (SELECT id FROM users WHERE email_lower IS NULL LIMIT $1)`, 100, func(done int) {
+		progress = append(progress, done)
+	})
+	if err != nil {
+		t.Fatalf("failed to backfill: %v", err)
+	}
+	if want := []int{100, 200, 250}; !slices.Equal(progress, want) {
+		t.Fatalf("expected progress %v, got %v", want, progress)
+	}
+
+	var remaining int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE email_lower IS DISTINCT FROM lower(email)`).Scan(&remaining); err != nil {
+		t.Fatalf("failed to count users: %v", err)
+	}
+	if remaining != 0 {
+		t.Fatalf("expected every row backfilled, %d left", remaining)
+	}
+}
+
+func TestExplicitTransactionRejected(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("BEGIN;\nCREATE TABLE users (id INT);\nCOMMIT;")},
+	}
+	for _, split := range []bool{false, true} {
+		m, err := New(db, migrations, WithStatementSplitting(split))
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		err = m.Run(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "BEGIN is not allowed in a migration") {
+			t.Fatalf("expected explicit BEGIN to be rejected with splitting=%v, got %v", split, err)
+		}
+	}
+}
+
+func TestKeepalivePingsAfterIdleTime(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_slow.sql":   &fstest.MapFile{Data: []byte("SELECT pg_sleep(0.05);\nSELECT pg_sleep(0.05);")},
+		"002_create.sql": &fstest.MapFile{Data: []byte("CREATE TABLE slow (id INT);")},
+	}
+	var logs strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	m, err := New(db, migrations,
+		WithStatementSplitting(true),
+		WithKeepalive(20*time.Millisecond),
+		// The check leaves the connection idle before each migration.
+		WithPreMigrationCheck(func(ctx context.Context, tx *sql.Tx, version string) error {
+			time.Sleep(30 * time.Millisecond)
+			return nil
+		}),
+		WithLogger(logger))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	pings := 0
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to parse log line %q: %v", line, err)
+		}
+		if record["msg"] == "pinged connection" {
+			pings++
+		}
+	}
+	// Slow statements keep the connection busy, so only the idle check
+	// before each migration triggers a ping.
+	if pings != 2 {
+		t.Fatalf("expected a ping after each idle pre-migration check, got %d", pings)
+	}
+}
+
+func TestRecheckAcceptsEditedMigration(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("-- users tabel\nCREATE TABLE users (id INT);")},
+	}
+	var audited []string
+	m, err := New(db, migrations,
+		WithVerifyChecksums(true),
+		WithAuditSink(func(version, sql string) { audited = append(audited, version+": "+sql) }))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	ctx := context.Background()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	migrations["001_create_users.sql"].Data = []byte("-- users table\nCREATE TABLE users (id INT);")
+	if err := m.Run(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch after editing an applied file, got %v", err)
+	}
+
+	audited = nil
+	if err := m.Recheck(ctx, "001_create_users"); err != nil {
+		t.Fatalf("failed to recheck migration: %v", err)
+	}
+	if len(audited) != 1 || !strings.HasPrefix(audited[0], "001_create_users: -- recheck:") {
+		t.Fatalf("expected the recheck to be audited, got %q", audited)
+	}
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("expected Run to succeed after Recheck, got %v", err)
+	}
+
+	if err := m.Recheck(ctx, "002_missing"); err == nil {
+		t.Fatal("expected rechecking an unapplied migration to fail")
+	}
+}
+
+func TestVerifyChecksumsRequiresDefaultTracker(t *testing.T) {
+	db, err := sql.Open("postgres", "")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = New(db, fstest.MapFS{}, WithVerifyChecksums(true), WithTracker(&tableTracker{table: `"other"`}))
+	if err == nil || !strings.Contains(err.Error(), "requires the default tracker") {
+		t.Fatalf("expected WithVerifyChecksums with a custom tracker to be rejected, got %v", err)
+	}
+}
+
+func TestErrorClassifier(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte("CREATE TABLE users (id INT);")},
+		"002_adopt_users.sql":  &fstest.MapFile{Data: []byte("CREATE TABLE users (id INT);")},
+		"003_add_email.sql":    &fstest.MapFile{Data: []byte("ALTER TABLE users ADD COLUMN email TEXT;")},
+	}
+	m, err := New(db, migrations, WithErrorClassifier(func(version string, err error) ErrorAction {
+		if sqlState(err) == "42P07" { // duplicate_table
+			return ErrorIgnore
+		}
+		return ErrorFail
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	ctx := context.Background()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("expected the duplicate table error to be ignored, got %v", err)
+	}
+	for _, version := range []string{"001_create_users", "002_adopt_users", "003_add_email"} {
+		applied, err := m.IsApplied(ctx, version)
+		if err != nil {
+			t.Fatalf("failed to check migration %s: %v", version, err)
+		}
+		if !applied {
+			t.Errorf("expected migration %s to be applied", version)
+		}
+	}
+
+	migrations["004_fail.sql"] = &fstest.MapFile{Data: []byte("SELECT 1/0;")}
+	attempts := 0
+	m, err = New(db, migrations, WithErrorClassifier(func(version string, err error) ErrorAction {
+		attempts++
+		return ErrorRetry
+	}))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(ctx); err == nil {
+		t.Fatal("expected a migration that keeps failing to fail the run")
+	}
+	if attempts != maxClassifiedRetries+1 {
+		t.Fatalf("expected %d attempts, got %d", maxClassifiedRetries+1, attempts)
+	}
+}
+
+func TestStatusReportsFilePath(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	files, err := fs.Glob(testMigrationsFS(t), "*.sql")
+	if err != nil {
+		t.Fatalf("failed to list migration files: %v", err)
+	}
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	if len(statuses) != len(files) {
+		t.Fatalf("expected %d statuses, got %d", len(files), len(statuses))
+	}
+	for i, status := range statuses {
+		if status.Path != files[i] {
+			t.Errorf("expected migration %s to be recorded from %s, got %q", status.Version, files[i], status.Path)
+		}
+	}
+}
+
+func TestMigrationFilesInSubdirectoriesAreIgnored(t *testing.T) {
+	m := newFileMigrator(t, fstest.MapFS{
+		"001_create_users.sql":         &fstest.MapFile{Data: []byte("CREATE TABLE users (id INT);")},
+		"archive/000_squashed.sql":     &fstest.MapFile{Data: []byte("CREATE TABLE legacy (id INT);")},
+		"down/001_create_users.sql":    &fstest.MapFile{Data: []byte("DROP TABLE users;")},
+		"archive/old/000_original.sql": &fstest.MapFile{Data: []byte("CREATE TABLE original (id INT);")},
+	})
+
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		t.Fatalf("failed to get migration files: %v", err)
+	}
+	if want := []string{"001_create_users.sql"}; !slices.Equal(files, want) {
+		t.Fatalf("expected only top-level files %v, got %v", want, files)
+	}
+}
+
+func TestStatusOnBaselineTable(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	// The layout of the first release, before any optional column.
+	if _, err := db.Exec(`
+		CREATE TABLE schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO schema_migrations (version) VALUES ('001_create_test_table'), ('002_add_test_column');`); err != nil {
+		t.Fatalf("failed to create baseline migrations table: %v", err)
+	}
+
+	m, err := New(db, testMigrationsFS(t))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.Skipped || status.Checksum != "" || status.Path != "" {
+			t.Errorf("unexpected status for %s: %+v", status.Version, status)
+		}
+	}
+	if err := m.EnsureMigrated(context.Background()); err != nil {
+		t.Fatalf("expected EnsureMigrated to succeed on a baseline table, got %v", err)
+	}
+}
+
+func TestVerifyChecksumsOfRenamedMigration(t *testing.T) {
+	db, _, closeDB := openDB(t)
+	defer closeDB()
+
+	m, err := New(db, fstest.MapFS{
+		"001_old.sql": &fstest.MapFile{Data: []byte("CREATE TABLE renamed (id INT);")},
+	}, WithVerifyChecksums(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	ctx := context.Background()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	m, err = New(db, fstest.MapFS{
+		"001_new.sql": &fstest.MapFile{Data: []byte("CREATE TABLE renamed (id INT, name TEXT);")},
+	}, WithVerifyChecksums(true))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+	if err := m.Run(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch for an edited renamed file, got %v", err)
+	}
+	if err := m.Recheck(ctx, "001_new"); err != nil {
+		t.Fatalf("failed to recheck renamed migration: %v", err)
+	}
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("expected Run to succeed after Recheck, got %v", err)
+	}
+}
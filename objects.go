@@ -0,0 +1,101 @@
+package migrator
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Object is a schema object named in a migration statement.
+type Object struct {
+	// Kind is the object type in lower case: table, index, view,
+	// materialized view, function, procedure, sequence or type.
+	Kind string
+	// Name is the object name as written, including any schema.
+	Name string
+}
+
+// ObjectChanges lists the objects a migration creates, drops and alters, in
+// statement order.
+type ObjectChanges struct {
+	Created []Object
+	Dropped []Object
+	Altered []Object
+}
+
+const objectKindPattern = `(TABLE|INDEX|VIEW|MATERIALIZED\s+VIEW|FUNCTION|PROCEDURE|SEQUENCE|TYPE)`
+
+var (
+	createObjectPattern = regexp.MustCompile(`(?is)^CREATE\s+(?:OR\s+REPLACE\s+)?(?:(?:GLOBAL|LOCAL)\s+)?(?:TEMP(?:ORARY)?\s+|UNLOGGED\s+)?(?:UNIQUE\s+)?` + objectKindPattern + `\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(` + sqlNamePattern + `)`)
+	alterObjectPattern  = regexp.MustCompile(`(?is)^ALTER\s+` + objectKindPattern + `\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?(` + sqlNamePattern + `)`)
+	dropObjectPattern   = regexp.MustCompile(`(?is)^DROP\s+` + objectKindPattern + `\s+(?:CONCURRENTLY\s+)?(?:IF\s+EXISTS\s+)?(.*)`)
+	objectNamePattern   = regexp.MustCompile(`^` + sqlNamePattern)
+	whitespacePattern   = regexp.MustCompile(`\s+`)
+)
+
+// Objects returns the tables, indexes, views, functions, procedures,
+// sequences and types a migration creates, drops or alters. Like Statements
+// it only reads the migration files. The statements are matched with
+// heuristics rather than parsed, so objects changed from within functions,
+// DO blocks or dynamic SQL are not listed. It returns an error if no
+// migration file has the version.
+func (m *Migrator) Objects(version string) (ObjectChanges, error) {
+	stmts, err := m.Statements(version)
+	if err != nil {
+		return ObjectChanges{}, err
+	}
+
+	var changes ObjectChanges
+	for _, stmt := range stmts {
+		sql := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(stmt, " "))
+		if match := createObjectPattern.FindStringSubmatch(sql); match != nil {
+			// The name of an unnamed index is chosen by the server.
+			if !strings.EqualFold(match[2], "ON") {
+				changes.Created = append(changes.Created, newObject(match[1], match[2]))
+			}
+		} else if match := alterObjectPattern.FindStringSubmatch(sql); match != nil {
+			changes.Altered = append(changes.Altered, newObject(match[1], match[2]))
+		} else if match := dropObjectPattern.FindStringSubmatch(sql); match != nil {
+			for _, name := range droppedNames(match[2]) {
+				changes.Dropped = append(changes.Dropped, newObject(match[1], name))
+			}
+		}
+	}
+	return changes, nil
+}
+
+func newObject(kind, name string) Object {
+	return Object{
+		Kind: strings.ToLower(whitespacePattern.ReplaceAllString(kind, " ")),
+		Name: name,
+	}
+}
+
+// droppedNames returns the object names in the list of a DROP statement,
+// leaving out function arguments and CASCADE or RESTRICT.
+func droppedNames(list string) []string {
+	var names []string
+	depth, start := 0, 0
+	for i := 0; i <= len(list); i++ {
+		if i < len(list) {
+			switch list[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+		if name := objectNamePattern.FindString(strings.TrimSpace(list[start:i])); name != "" {
+			names = append(names, name)
+		}
+		start = i + 1
+	}
+	return names
+}
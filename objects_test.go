@@ -0,0 +1,50 @@
+package migrator
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestObjects(t *testing.T) {
+	migrations := fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`-- users and their lookup index
+CREATE TABLE IF NOT EXISTS public.users (id INT, email TEXT);
+CREATE UNIQUE INDEX CONCURRENTLY users_email_idx ON users (email);
+CREATE INDEX ON users (id);
+CREATE OR REPLACE FUNCTION "Normalize"(email TEXT) RETURNS TEXT AS $$ SELECT lower(email) $$ LANGUAGE sql;
+ALTER TABLE ONLY users ADD COLUMN name TEXT;
+DROP VIEW IF EXISTS legacy_users, old_users CASCADE;
+DROP FUNCTION legacy_hash(TEXT, INT);
+CREATE VIEW "odd ""name""" AS SELECT 1;
+INSERT INTO users VALUES (1, 'a@example.com');
+`)},
+	}
+	m := newFileMigrator(t, migrations)
+
+	changes, err := m.Objects("001_create_users")
+	if err != nil {
+		t.Fatalf("failed to list objects: %v", err)
+	}
+	want := ObjectChanges{
+		Created: []Object{
+			{Kind: "table", Name: "public.users"},
+			{Kind: "index", Name: "users_email_idx"},
+			{Kind: "function", Name: `"Normalize"`},
+			{Kind: "view", Name: `"odd ""name"""`},
+		},
+		Altered: []Object{{Kind: "table", Name: "users"}},
+		Dropped: []Object{
+			{Kind: "view", Name: "legacy_users"},
+			{Kind: "view", Name: "old_users"},
+			{Kind: "function", Name: "legacy_hash"},
+		},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("unexpected objects:\n got %+v\nwant %+v", changes, want)
+	}
+
+	if _, err := m.Objects("002_missing"); err == nil {
+		t.Fatal("expected an error for a missing version")
+	}
+}
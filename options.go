@@ -1,14 +1,79 @@
 package migrator
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"hash"
 	"io"
 	"log/slog"
+	"strings"
+	"time"
 )
 
 type config struct {
 	tableName string
 	lockID    int64
 	logger    *slog.Logger
+	scheme    VersionScheme
+	appliedBy string
+	checksum  func([]byte) string
+
+	// checksumHash computes the default checksum incrementally, for files
+	// that are streamed. It is nil when a custom checksum is configured.
+	checksumHash func() hash.Hash
+
+	environment     string
+	splitStatements bool
+	onTableCreated  func(ctx context.Context, tx *sql.Tx) error
+	skipUnreadable  bool
+	applicationName string
+	versionWidth    int
+	maxFileSize     int64
+	streamThreshold int64
+	tracker         Tracker
+
+	tableLockTimeout time.Duration
+	reportFile       string
+	tracer           Tracer
+	assumeFresh      bool
+	waitForLock      time.Duration
+
+	requireMigrations bool
+	transactionLock   bool
+	backupHandler     func(ctx context.Context, tx *sql.Tx, table string) error
+	skipPattern       string
+	preMigrationCheck func(ctx context.Context, tx *sql.Tx, version string) error
+	rowLevelLock      bool
+
+	requireAppliedFiles bool
+	params              map[string]any
+	statementTag        string
+	lockStrategy        LockStrategy
+	statementTiming     bool
+	manifest            string
+	allowUnlisted       bool
+	runRetries          int
+	runRetryBackoff     time.Duration
+	minServerVersion    string
+	redactSQL           func(sql string) string
+	sessionSettings     map[string]string
+	slowThreshold       time.Duration
+	postMigrationSQL    []string
+	rewriteWarnings     bool
+	decompressors       map[string]Decompressor
+	sizeTables          []string
+	runType             RunType
+	auditSink           func(version, sql string)
+	recordFunc          func(ctx context.Context, tx *sql.Tx, record Record) error
+	timestampTZ         bool
+	confirm             ConfirmFunc
+	keepalive           time.Duration
+	verifyChecksums     bool
+	errorClassifier     func(version string, err error) ErrorAction
+	tableLockRetries    int
+	tableLockBackoff    time.Duration
 }
 
 func defaultConfig() config {
@@ -16,13 +81,64 @@ func defaultConfig() config {
 		tableName: "schema_migrations",
 		lockID:    5764249691895432819, // FNV-1a hash of "github.com/marcelom97/migrator"
 		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		scheme:    Lexical,
+		checksum:  sha256Hex,
+
+		checksumHash:    sha256.New,
+		applicationName: "migrator",
+		versionWidth:    3,
+		tracer:          noopTracer{},
+		redactSQL:       func(sql string) string { return sql },
 	}
 }
 
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// LockStrategy determines how Run serializes concurrent migrators.
+type LockStrategy int
+
+const (
+	// AdvisoryLock takes a PostgreSQL advisory lock, so a second migrator
+	// fails fast with "another migration is in progress", and also locks
+	// the migrations table. This is the default.
+	AdvisoryLock LockStrategy = iota
+
+	// TableLock only locks the migrations table, for roles that may not
+	// call the advisory lock functions. A second migrator waits for the
+	// table lock instead of failing fast. It does not serialize migrators
+	// using a custom Tracker.
+	TableLock
+
+	// NoLock disables locking, for deploys that never run more than one
+	// migrator at a time.
+	NoLock
+)
+
+// RunType selects which kinds of migrations Run applies, so schema and data
+// migrations can be scheduled separately.
+type RunType int
+
+const (
+	// RunAll applies every migration. This is the default.
+	RunAll RunType = iota
+
+	// RunSchema applies only schema migrations, which are migrations
+	// without a "type data" directive.
+	RunSchema
+
+	// RunData applies only migrations with a "type data" directive.
+	RunData
+)
+
 // Option configures the Migrator.
 type Option func(*config)
 
-// WithTableName sets the name of the migrations tracking table.
+// WithTableName sets the name of the migrations tracking table, optionally
+// qualified with a schema as "schema.table". Each part must match
+// [A-Za-z_][A-Za-z0-9_]*; New returns an error otherwise.
 // Default: "schema_migrations".
 func WithTableName(name string) Option {
 	return func(c *config) {
@@ -30,7 +146,10 @@ func WithTableName(name string) Option {
 	}
 }
 
-// WithLockID sets the PostgreSQL advisory lock ID.
+// WithLockID sets the PostgreSQL advisory lock ID. Migrators for independent
+// migration sets, e.g. one per schema in a monorepo, can use distinct IDs
+// along with distinct tables to run concurrently, each holding its lock on
+// its own connection.
 // Default: 5764249691895432819.
 func WithLockID(id int64) Option {
 	return func(c *config) {
@@ -45,3 +164,540 @@ func WithLogger(logger *slog.Logger) Option {
 		c.logger = logger
 	}
 }
+
+// WithVersionScheme sets how migration file names are parsed and ordered.
+// Default: Lexical.
+func WithVersionScheme(scheme VersionScheme) Option {
+	return func(c *config) {
+		c.scheme = scheme
+	}
+}
+
+// WithAppliedBy records an identifier, such as a git SHA or build tag, in the
+// applied_by column of every migration the Migrator records.
+// Default: none (NULL).
+func WithAppliedBy(id string) Option {
+	return func(c *config) {
+		c.appliedBy = id
+	}
+}
+
+// WithStatementSplitting executes each migration statement by statement
+// instead of sending the whole file at once. Files are checked for
+// unterminated quotes, dollar-quoted bodies and comments before anything runs.
+// Default: false.
+func WithStatementSplitting(enabled bool) Option {
+	return func(c *config) {
+		c.splitStatements = enabled
+	}
+}
+
+// WithEnvironment sets the environment Run applies migrations for.
+// Migrations declaring "-- migrator:env dev,staging" run only when the
+// environment is listed; otherwise they are recorded as skipped without
+// running, as with a skip-if directive.
+// Default: none, which skips every environment-restricted migration.
+func WithEnvironment(name string) Option {
+	return func(c *config) {
+		c.environment = name
+	}
+}
+
+// WithOnTableCreated sets a hook that runs in the migration transaction right
+// after the migrations table is first created, e.g. to grant permissions on
+// it. It does not run when the table already exists. A non-nil error aborts
+// the run.
+func WithOnTableCreated(fn func(ctx context.Context, tx *sql.Tx) error) Option {
+	return func(c *config) {
+		c.onTableCreated = fn
+	}
+}
+
+// WithChecksum sets the function used to compute the checksum stored for each
+// migration file, e.g. to match the checksums of a tool being migrated from.
+// Files are always read in full when a custom checksum is set, see
+// WithStreamThreshold.
+// Default: hex-encoded SHA-256 of the file content.
+func WithChecksum(fn func(content []byte) string) Option {
+	return func(c *config) {
+		c.checksum = fn
+		c.checksumHash = nil
+	}
+}
+
+// WithSkipUnreadable logs and skips migration files that cannot be opened
+// instead of failing, for best-effort tooling over composed filesystems. To
+// find them it opens every file on each run, applied or not.
+// Default: false, which fails with an error naming the file.
+func WithSkipUnreadable(skip bool) Option {
+	return func(c *config) {
+		c.skipUnreadable = skip
+	}
+}
+
+// WithApplicationName sets the application_name of the connection holding the
+// migration lock, making it identifiable in pg_stat_activity during deploys.
+// The connection's previous value is restored afterwards. An empty name
+// leaves application_name unchanged.
+// Default: "migrator".
+func WithApplicationName(name string) Option {
+	return func(c *config) {
+		c.applicationName = name
+	}
+}
+
+// WithVersionWidth sets the zero-padded width of versions returned by
+// NextVersion for the Lexical scheme.
+// Default: 3, e.g. "001".
+func WithVersionWidth(width int) Option {
+	return func(c *config) {
+		c.versionWidth = width
+	}
+}
+
+// WithMaxFileSize rejects migration files larger than the given number of
+// bytes before reading them, guarding against data dumps accidentally
+// embedded as migrations. Load large data sets by other means, such as COPY
+// from a file outside the migrations.
+// Default: 0, which means unlimited.
+func WithMaxFileSize(bytes int64) Option {
+	return func(c *config) {
+		c.maxFileSize = bytes
+	}
+}
+
+// WithStreamThreshold streams migration files larger than the given number of
+// bytes instead of reading them into memory, executing them statement by
+// statement as they are read. It requires WithStatementSplitting and the
+// default checksum. Because a streamed file is not split in full up front, a
+// truncated one is only detected after its earlier statements ran; the
+// transaction is still rolled back.
+// Default: 0, which never streams.
+func WithStreamThreshold(bytes int64) Option {
+	return func(c *config) {
+		c.streamThreshold = bytes
+	}
+}
+
+// WithTracker sets where applied migrations are recorded. When set, the
+// migrations table is neither created nor locked; the advisory lock still
+// serializes runs.
+// Default: the migrations table.
+func WithTracker(tracker Tracker) Option {
+	return func(c *config) {
+		c.tracker = tracker
+	}
+}
+
+// WithTableLockTimeout bounds how long Run waits to lock the migrations
+// table, failing with ErrTableLocked instead of hanging the deploy while
+// another session holds a conflicting lock.
+// Default: 0, which waits indefinitely.
+func WithTableLockTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.tableLockTimeout = d
+	}
+}
+
+// WithReportFile makes Run write a JSON report of the run to path, listing the
+// migrations it applied or skipped with their checksums, start times and
+// durations, and the resulting head version. The report is written even when
+// Run fails, with the error recorded, so it can be kept as a deploy artifact.
+// Default: no report.
+func WithReportFile(path string) Option {
+	return func(c *config) {
+		c.reportFile = path
+	}
+}
+
+// WithTracer sets the Tracer used to create spans for Run and for each
+// migration it executes.
+// Default: no spans.
+func WithTracer(tracer Tracer) Option {
+	return func(c *config) {
+		c.tracer = tracer
+	}
+}
+
+// WithAssumeFresh makes GenerateSQL treat every migration as pending instead
+// of reading the applied migrations from the database, for generating the
+// script of a database that does not exist yet.
+// Default: false.
+func WithAssumeFresh(fresh bool) Option {
+	return func(c *config) {
+		c.assumeFresh = fresh
+	}
+}
+
+// WithWaitForLock makes Run wait up to timeout for a concurrent migration to
+// finish instead of failing immediately with "another migration is in
+// progress". Once the other instance commits and releases the lock, Run
+// proceeds and finds nothing left to apply, so every replica can call Run at
+// startup and return only when the schema is current.
+// Default: 0, which fails immediately.
+func WithWaitForLock(timeout time.Duration) Option {
+	return func(c *config) {
+		c.waitForLock = timeout
+	}
+}
+
+// WithRequireMigrations makes Run return an error when the migrations FS
+// contains no migration files, which usually means a misconfigured embed
+// directive or path. Enabling it is recommended.
+// Default: false.
+func WithRequireMigrations(require bool) Option {
+	return func(c *config) {
+		c.requireMigrations = require
+	}
+}
+
+// WithTransactionLock makes Run take the advisory lock with
+// pg_try_advisory_xact_lock inside the migration transaction instead of
+// holding a session lock around it. PostgreSQL releases the lock when the
+// transaction commits or rolls back, so it cannot outlive a failed run. Both
+// kinds of lock use the same lock ID and exclude each other.
+// Default: false.
+func WithTransactionLock(enabled bool) Option {
+	return func(c *config) {
+		c.transactionLock = enabled
+	}
+}
+
+// WithBackupHandler sets the function called for each table named in a
+// migration's "-- migrator:backup <table>" directive, before the migration
+// runs and within the same transaction, e.g. to copy the table with CREATE
+// TABLE ... AS SELECT. A migration with a backup directive fails when no
+// handler is set.
+// Default: none.
+func WithBackupHandler(handler func(ctx context.Context, tx *sql.Tx, table string) error) Option {
+	return func(c *config) {
+		c.backupHandler = handler
+	}
+}
+
+// WithSkipPattern excludes migrations whose version matches the glob pattern
+// (see path.Match), e.g. "002_*", from Run. Excluded migrations are not
+// recorded, so they run on the next Run without the option, after any later
+// migrations that were applied in the meantime. Intended for isolating a
+// problematic migration during development.
+// Default: none.
+func WithSkipPattern(pattern string) Option {
+	return func(c *config) {
+		c.skipPattern = pattern
+	}
+}
+
+// WithPreMigrationCheck sets a function called before each pending migration,
+// e.g. to verify that replication lag is low. A non-nil error aborts Run
+// before that migration; because all migrations share one transaction,
+// nothing from the run is committed.
+// Default: none.
+func WithPreMigrationCheck(check func(ctx context.Context, tx *sql.Tx, version string) error) Option {
+	return func(c *config) {
+		c.preMigrationCheck = check
+	}
+}
+
+// WithRowLevelLock makes Run serialize writers by locking a sentinel row with
+// SELECT ... FOR UPDATE instead of taking an ACCESS EXCLUSIVE lock on the
+// migrations table, so Status and other readers are not blocked while
+// migrations run. The row lives in a companion table named after the
+// migrations table with a "_lock" suffix, created on first use.
+// Default: false.
+func WithRowLevelLock(enabled bool) Option {
+	return func(c *config) {
+		c.rowLevelLock = enabled
+	}
+}
+
+// WithRequireAllAppliedFilesPresent makes Run fail before applying anything
+// when a recorded migration no longer has a file, e.g. because it was
+// deleted, so drift between the files and the database does not compound
+// across deploys.
+// Default: false.
+func WithRequireAllAppliedFilesPresent(require bool) Option {
+	return func(c *config) {
+		c.requireAppliedFiles = require
+	}
+}
+
+// WithMigrationParams binds runtime values to $name references in migration
+// SQL, e.g. $plan in "INSERT INTO settings (name, value) VALUES ('plan',
+// $plan)". References are rewritten to positional parameters and the values
+// sent separately, so they cannot inject SQL. As with any bound parameter,
+// they are only accepted where PostgreSQL allows one, such as in INSERT,
+// UPDATE and SELECT but not in DDL. References inside
+// strings, comments and dollar-quoted bodies are not replaced, and an unknown
+// name fails the migration. Requires WithStatementSplitting, since a
+// parameterized query holds a single statement.
+// Default: none.
+func WithMigrationParams(params map[string]any) Option {
+	return func(c *config) {
+		c.params = params
+	}
+}
+
+// WithStatementTag prepends a comment naming the tag and the migration
+// version, such as "/* migrator: 001_create_users */", to the SQL executed
+// for each migration, so its statements can be attributed in
+// pg_stat_statements and server logs.
+// Default: no comment.
+func WithStatementTag(tag string) Option {
+	return func(c *config) {
+		c.statementTag = tag
+	}
+}
+
+// WithLockStrategy sets how Run serializes concurrent migrators. Options that
+// configure the advisory lock, such as WithLockID, WithTransactionLock and
+// WithWaitForLock, only apply to AdvisoryLock.
+// Default: AdvisoryLock.
+func WithLockStrategy(strategy LockStrategy) Option {
+	return func(c *config) {
+		c.lockStrategy = strategy
+	}
+}
+
+// WithStatementTiming logs the duration of every statement, identified by the
+// migration version, its position in the file and the start of its SQL, to
+// help find the slow statement in a large migration. It only has an effect
+// with WithStatementSplitting.
+// Default: false.
+func WithStatementTiming(enabled bool) Option {
+	return func(c *config) {
+		c.statementTiming = enabled
+	}
+}
+
+// WithManifest orders migrations by the manifest file at path in the
+// migrations FS instead of by file name. The manifest lists one migration
+// file per line; blank lines and lines starting with "#" are ignored. Listing
+// a file that does not exist is an error, and so is a migration file missing
+// from the manifest unless WithAllowUnlistedFiles is set.
+// Default: order by file name.
+func WithManifest(path string) Option {
+	return func(c *config) {
+		c.manifest = path
+	}
+}
+
+// WithAllowUnlistedFiles makes migration files that are not listed in the
+// WithManifest manifest be ignored with a warning instead of failing.
+// Default: false.
+func WithAllowUnlistedFiles(allow bool) Option {
+	return func(c *config) {
+		c.allowUnlisted = allow
+	}
+}
+
+// WithRunRetry makes Run start over up to attempts more times when the
+// connection is lost mid-run, e.g. during a failover, waiting backoff before
+// the first retry and twice as long before each further one. Retrying is safe
+// because the failed run's transaction rolled back. Errors from the SQL
+// itself are never retried.
+// Default: no retries.
+func WithRunRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.runRetries = attempts
+		c.runRetryBackoff = backoff
+	}
+}
+
+// WithMinServerVersion makes Run fail before applying anything when the
+// PostgreSQL server is older than version, given as "<major>" or
+// "<major>.<minor>", e.g. "14" or "14.2".
+// Default: any version.
+func WithMinServerVersion(version string) Option {
+	return func(c *config) {
+		c.minServerVersion = version
+	}
+}
+
+// WithSQLRedactor sets a function applied to migration SQL before it is
+// logged, e.g. by WithStatementTiming, so secrets seeded by a migration do
+// not end up in the logs.
+// Default: SQL is logged as is.
+func WithSQLRedactor(redact func(sql string) string) Option {
+	return func(c *config) {
+		c.redactSQL = redact
+	}
+}
+
+// WithSessionSettings sets run-time parameters such as timezone or
+// client_encoding for the migration transaction, as SET LOCAL does, so data
+// migrations behave the same regardless of server and role defaults. Names
+// are validated by New; values are passed as parameters.
+// Default: the session defaults apply.
+func WithSessionSettings(settings map[string]string) Option {
+	return func(c *config) {
+		c.sessionSettings = settings
+	}
+}
+
+// WithSlowMigrationThreshold logs a warning for each migration that takes
+// longer than d and flags it as slow in the WithReportFile report. Slow
+// migrations are not aborted.
+// Default: 0 (disabled).
+func WithSlowMigrationThreshold(d time.Duration) Option {
+	return func(c *config) {
+		c.slowThreshold = d
+	}
+}
+
+// WithPostMigrationSQL sets statements run in the migration transaction after
+// the pending migrations, e.g. to refresh a materialized view, so they commit
+// or roll back together with the migrations. They run only when the run
+// applied at least one migration.
+// Default: none.
+func WithPostMigrationSQL(statements []string) Option {
+	return func(c *config) {
+		c.postMigrationSQL = statements
+	}
+}
+
+// WithRewriteWarnings logs a warning before each ALTER TABLE statement that
+// would rewrite the whole table, such as a column type change or a column
+// added with a volatile default. The check is a heuristic on the statement
+// text; the statement still runs.
+// Default: false.
+func WithRewriteWarnings(enabled bool) Option {
+	return func(c *config) {
+		c.rewriteWarnings = enabled
+	}
+}
+
+// WithDecompressor registers a decompressor for migration files named with
+// ".sql" followed by ext, e.g. ".gz" for "001_create_users.sql.gz". The
+// version strips both extensions, so compressing a file does not change it.
+// Checksums are computed over the decompressed SQL. It can be used several
+// times to register several extensions.
+// Default: only plain .sql files are read.
+func WithDecompressor(ext string, d Decompressor) Option {
+	return func(c *config) {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if c.decompressors == nil {
+			c.decompressors = make(map[string]Decompressor)
+		}
+		c.decompressors[ext] = d
+	}
+}
+
+// WithTableSizeDelta measures the total size of the given tables, including
+// indexes and TOAST data, before and after the migrations. The sizes and
+// their change are logged and written to the WithReportFile report. Tables
+// that do not exist count as 0 bytes.
+// Default: none.
+func WithTableSizeDelta(tables []string) Option {
+	return func(c *config) {
+		c.sizeTables = tables
+	}
+}
+
+// WithRunType sets which kinds of migrations Run and GenerateSQL apply.
+// Migrations of the other kind are skipped without being recorded, so a
+// later run of that kind applies them in order.
+// Default: RunAll.
+func WithRunType(t RunType) Option {
+	return func(c *config) {
+		c.runType = t
+	}
+}
+
+// WithAuditSink sets a function that receives the SQL each migration
+// executed, for audit trails. It is called once per applied migration after
+// the run commits, so it only sees SQL that took effect, and the SQL passes
+// through the WithSQLRedactor function first.
+// Default: none.
+func WithAuditSink(sink func(version, sql string)) Option {
+	return func(c *config) {
+		c.auditSink = sink
+	}
+}
+
+// WithRecordFunc replaces the INSERT that records an applied migration, e.g.
+// to fill additional columns of the migrations table or to add ON CONFLICT
+// DO NOTHING. The function runs in the migration transaction. Reading the
+// applied versions is unchanged; use WithTracker to replace both.
+// Default: the tracker's MarkApplied.
+func WithRecordFunc(record func(ctx context.Context, tx *sql.Tx, record Record) error) Option {
+	return func(c *config) {
+		c.recordFunc = record
+	}
+}
+
+// WithTimestampTZ creates the applied_at column of the migrations table as
+// TIMESTAMPTZ, so applied times are unambiguous across session time zones.
+// An existing TIMESTAMP column is converted on the next run, reading its
+// values in the session time zone of that run.
+// Default: false, which keeps TIMESTAMP.
+func WithTimestampTZ(enabled bool) Option {
+	return func(c *config) {
+		c.timestampTZ = enabled
+	}
+}
+
+// WithConfirm sets a function asked before Run applies pending migrations,
+// with a prompt listing them. When it declines, Run returns ErrNotConfirmed
+// without applying anything. The prompt is shown before the run takes its
+// locks, and stops at the first pause directive; if the pending migrations
+// change before the locks are held, Run fails with ErrNotConfirmed.
+// VerifyAgainst never asks. PromptConfirm provides one for command-line
+// tools.
+// Default: none, so Run never asks.
+func WithConfirm(confirm ConfirmFunc) Option {
+	return func(c *config) {
+		c.confirm = confirm
+	}
+}
+
+// WithKeepalive pings the connection of a run with SELECT 1 before a
+// migration or statement when it has been idle for interval since the last
+// migration statement, e.g. during a slow backup handler or pre-migration
+// check. This surfaces a dead connection before the migration runs. Time
+// spent in statements does not count as idle.
+// Default: 0, which never pings.
+func WithKeepalive(interval time.Duration) Option {
+	return func(c *config) {
+		c.keepalive = interval
+	}
+}
+
+// WithVerifyChecksums makes Run fail with ErrChecksumMismatch when the file of
+// an applied migration no longer matches the checksum recorded for it, before
+// anything is applied. Use Recheck to accept an intentional edit. Migrations
+// recorded without a checksum are not checked. It requires the default
+// tracker.
+// Default: false.
+func WithVerifyChecksums(enabled bool) Option {
+	return func(c *config) {
+		c.verifyChecksums = enabled
+	}
+}
+
+// WithErrorClassifier sets a function consulted when a migration fails, which
+// can fail the run, ignore the error and record the migration as applied, or
+// retry the migration. Each migration then runs within a savepoint, which is
+// rolled back before it is ignored or retried, so an ignored migration leaves
+// no changes behind.
+// Default: none, so every error fails the run.
+func WithErrorClassifier(classify func(version string, err error) ErrorAction) Option {
+	return func(c *config) {
+		c.errorClassifier = classify
+	}
+}
+
+// WithTableLockRetry makes Run try to lock the migrations table up to
+// attempts more times when WithTableLockTimeout expires, waiting backoff
+// before the first retry and twice as long before each further one, for
+// databases where conflicting locks are short but frequent. Unlike
+// WithRunRetry it retries only the lock, within the same transaction. New
+// returns an error if WithTableLockTimeout is not set.
+// Default: no retries.
+func WithTableLockRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.tableLockRetries = attempts
+		c.tableLockBackoff = backoff
+	}
+}
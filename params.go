@@ -0,0 +1,146 @@
+package migrator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bindParams rewrites the $name references in a statement to positional
+// parameters and returns the values to bind, in order. References inside
+// quoted strings, quoted identifiers, dollar-quoted bodies and comments are
+// left alone, as are positional parameters such as $1 and dollar-quote tags
+// such as $body$. A name repeated in the statement is bound once.
+func bindParams(stmt string, params map[string]any) (string, []any, error) {
+	var (
+		b         strings.Builder
+		args      []any
+		positions = make(map[string]int)
+	)
+
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		var prev byte
+		if i > 0 {
+			prev = stmt[i-1]
+		}
+
+		switch {
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end < 0 {
+				end = len(stmt) - i
+			}
+			b.WriteString(stmt[i : i+end])
+			i += end
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			end := blockCommentEnd(stmt, i)
+			b.WriteString(stmt[i:end])
+			i = end
+		case c == '\'' || c == '"':
+			escapes := c == '\'' && (prev == 'E' || prev == 'e') && (i < 2 || !isIdentByte(stmt[i-2]))
+			end := quotedEnd(stmt, i, escapes)
+			b.WriteString(stmt[i:end])
+			i = end
+		case c == '$' && !isIdentByte(prev):
+			name := paramName(stmt[i+1:])
+			if name == "" || strings.HasPrefix(stmt[i+1+len(name):], "$") {
+				// A dollar-quoted body, or a $1 parameter.
+				end := dollarQuotedEnd(stmt, i)
+				b.WriteString(stmt[i:end])
+				i = end
+				continue
+			}
+			value, ok := params[name]
+			if !ok {
+				return "", nil, fmt.Errorf("unknown parameter $%s", name)
+			}
+			pos, ok := positions[name]
+			if !ok {
+				args = append(args, value)
+				pos = len(args)
+				positions[name] = pos
+			}
+			fmt.Fprintf(&b, "$%d", pos)
+			i += 1 + len(name)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), args, nil
+}
+
+// paramName returns the parameter name at the start of s: a letter or
+// underscore followed by letters, digits and underscores.
+func paramName(s string) string {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9' {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
+
+// blockCommentEnd returns the index just past the nested block comment
+// starting at i.
+func blockCommentEnd(s string, i int) int {
+	depth := 0
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(s[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return len(s)
+}
+
+// quotedEnd returns the index just past the quoted string or identifier
+// starting at i.
+func quotedEnd(s string, i int, escapes bool) int {
+	quote := s[i]
+	for i++; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarQuotedEnd returns the index just past the dollar-quoted body starting
+// at i, or just past the dollar sign when it does not open a tag.
+func dollarQuotedEnd(s string, i int) int {
+	j := i + 1
+	for j < len(s) && s[j] != '$' {
+		if !isIdentByte(s[j]) || (j == i+1 && s[j] >= '0' && s[j] <= '9') {
+			return i + 1
+		}
+		j++
+	}
+	if j >= len(s) {
+		return i + 1
+	}
+	delim := s[i : j+1]
+	end := strings.Index(s[j+1:], delim)
+	if end < 0 {
+		return len(s)
+	}
+	return j + 1 + end + len(delim)
+}
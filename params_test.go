@@ -0,0 +1,55 @@
+package migrator
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBindParams(t *testing.T) {
+	params := map[string]any{"plan": "free", "limit": 10}
+
+	tests := []struct {
+		name     string
+		stmt     string
+		expected string
+		args     []any
+	}{
+		{
+			name:     "named references",
+			stmt:     "INSERT INTO settings VALUES ($plan, $limit, $plan)",
+			expected: "INSERT INTO settings VALUES ($1, $2, $1)",
+			args:     []any{"free", 10},
+		},
+		{
+			name:     "ignores strings, identifiers and comments",
+			stmt:     "SELECT '$plan', \"$plan\", E'\\'$plan' -- $plan\n/* $plan */",
+			expected: "SELECT '$plan', \"$plan\", E'\\'$plan' -- $plan\n/* $plan */",
+		},
+		{
+			name:     "ignores dollar-quoted bodies and positional parameters",
+			stmt:     "SELECT $body$ $plan $body$, $$ $limit $$, $1",
+			expected: "SELECT $body$ $plan $body$, $$ $limit $$, $1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := bindParams(tt.stmt, params)
+			if err != nil {
+				t.Fatalf("failed to bind params: %v", err)
+			}
+			if query != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, query)
+			}
+			if !slices.Equal(args, tt.args) {
+				t.Fatalf("expected args %v, got %v", tt.args, args)
+			}
+		})
+	}
+
+	t.Run("unknown parameter returns error", func(t *testing.T) {
+		if _, _, err := bindParams("SELECT $missing", params); err == nil {
+			t.Fatal("expected error for unknown parameter, got nil")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package migrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runReport is the JSON document written by Run when WithReportFile is set.
+type runReport struct {
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Migrations []reportEntry `json:"migrations"`
+	// Head is the last applied version after the run. Because all
+	// migrations share one transaction, a failed run leaves it unchanged.
+	Head string `json:"head"`
+	// Committed reports whether the migrations listed were committed. It is
+	// false when Error is set.
+	Committed bool `json:"committed"`
+	// PausedAt is the version whose pause directive stopped the run.
+	PausedAt string `json:"paused_at,omitempty"`
+	// TableSizes lists the WithTableSizeDelta measurements.
+	TableSizes []tableSize `json:"table_sizes,omitempty"`
+	Error      string      `json:"error,omitempty"`
+
+	initialHead string
+	audit       []auditEntry
+	// confirmed lists the versions the WithConfirm function approved. It is
+	// nil when no confirmation was asked for.
+	confirmed []string
+}
+
+// applied returns the number of migrations the run executed, leaving out
+// those recorded as skipped.
+func (r *runReport) applied() int {
+	n := 0
+	for _, entry := range r.Migrations {
+		if !entry.Skipped {
+			n++
+		}
+	}
+	return n
+}
+
+// auditEntry is the SQL a migration executed, passed to the WithAuditSink
+// function once the run commits.
+type auditEntry struct {
+	version string
+	sql     string
+}
+
+type reportEntry struct {
+	Version    string    `json:"version"`
+	Checksum   string    `json:"checksum"`
+	Skipped    bool      `json:"skipped"`
+	AppliedAt  time.Time `json:"applied_at"`
+	DurationMS int64     `json:"duration_ms"`
+	// Slow reports that the migration exceeded WithSlowMigrationThreshold.
+	Slow  bool   `json:"slow,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// tableSize is the total size in bytes of a table, with its indexes and
+// TOAST data, before and after the migrations.
+type tableSize struct {
+	Table  string `json:"table"`
+	Before int64  `json:"before_bytes"`
+	After  int64  `json:"after_bytes"`
+	Delta  int64  `json:"delta_bytes"`
+}
+
+// writeReport writes the report for a run that finished with runErr.
+func (m *Migrator) writeReport(report *runReport, runErr error) error {
+	if report.Migrations == nil {
+		report.Migrations = []reportEntry{}
+	}
+	report.FinishedAt = time.Now().UTC()
+	report.Committed = runErr == nil
+	if runErr != nil {
+		report.Error = runErr.Error()
+		report.Head = report.initialHead
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(m.cfg.reportFile, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report %s: %w", m.cfg.reportFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package migrator
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	sqlCommentPattern = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+	alterTablePattern = regexp.MustCompile(`^ALTER\s+TABLE\b`)
+)
+
+// rewriteRules lists ALTER TABLE actions that rewrite or copy every row of
+// the table under an ACCESS EXCLUSIVE lock, following PostgreSQL's rules for
+// each action. They are heuristics on the statement text: binary-compatible
+// type changes, for example, are flagged although they skip the rewrite.
+var rewriteRules = []struct {
+	pattern *regexp.Regexp
+	reason  string
+}{
+	{regexp.MustCompile(`(?:^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + sqlNamePattern + `(?:\s*\*)?\s+|,\s*)ALTER\s+(?:COLUMN\s+)?` + sqlIdentPattern + `\s+(?:SET\s+DATA\s+)?TYPE\b`), "changing a column type rewrites the table"},
+	{regexp.MustCompile(`\bADD\b.*\b(SMALL|BIG)?SERIAL\d?\b`), "adding a serial column fills every row"},
+	{regexp.MustCompile(`\bADD\b.*\bGENERATED\s+ALWAYS\s+AS\s*\(.*\)\s*STORED\b`), "adding a stored generated column fills every row"},
+	{regexp.MustCompile(`\bADD\b.*\bDEFAULT\b.*\b(RANDOM|CLOCK_TIMESTAMP|TIMEOFDAY|GEN_RANDOM_UUID|UUID_GENERATE_V[14]|NEXTVAL)\s*\(`), "adding a column with a volatile default rewrites the table"},
+	{regexp.MustCompile(`\bSET\s+(UN)?LOGGED\b`), "changing the table to logged or unlogged rewrites it"},
+	{regexp.MustCompile(`\bSET\s+ACCESS\s+METHOD\b`), "changing the access method rewrites the table"},
+	{regexp.MustCompile(`\bSET\s+TABLESPACE\b`), "moving the table to another tablespace copies it"},
+}
+
+// rewriteReason returns why an ALTER TABLE statement would rewrite the whole
+// table, or "" if it is not expected to. Whitespace is collapsed first, so
+// the rules match statements spread over several lines.
+func rewriteReason(sql string) string {
+	sql = whitespacePattern.ReplaceAllString(sqlCommentPattern.ReplaceAllString(sql, " "), " ")
+	sql = strings.ToUpper(strings.TrimSpace(sql))
+	if !alterTablePattern.MatchString(sql) {
+		return ""
+	}
+	for _, rule := range rewriteRules {
+		if rule.pattern.MatchString(sql) {
+			return rule.reason
+		}
+	}
+	return ""
+}
+
+// warnRewrite logs a warning when WithRewriteWarnings is set and stmt would
+// rewrite a table.
+func (m *Migrator) warnRewrite(mig *migration, stmt statement) {
+	if !m.cfg.rewriteWarnings {
+		return
+	}
+	if reason := rewriteReason(stmt.sql); reason != "" {
+		m.cfg.logger.Warn("statement may rewrite the table", "version", mig.version, "line", stmt.line, "reason", reason)
+	}
+}
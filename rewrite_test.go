@@ -0,0 +1,32 @@
+package migrator
+
+import "testing"
+
+func TestRewriteReason(t *testing.T) {
+	tests := []struct {
+		sql     string
+		rewrite bool
+	}{
+		{sql: "ALTER TABLE users ALTER COLUMN id SET DATA TYPE BIGINT", rewrite: true},
+		{sql: "alter table users alter id type bigint", rewrite: true},
+		{sql: "ALTER TABLE users ADD COLUMN id2 BIGSERIAL", rewrite: true},
+		{sql: "ALTER TABLE users ADD COLUMN token UUID DEFAULT gen_random_uuid()", rewrite: true},
+		{sql: "ALTER TABLE users ADD COLUMN total INT GENERATED ALWAYS AS (a + b) STORED", rewrite: true},
+		{sql: "ALTER TABLE users SET UNLOGGED", rewrite: true},
+		{sql: "ALTER TABLE users\n  ADD COLUMN token UUID\n  DEFAULT gen_random_uuid()", rewrite: true},
+		{sql: "ALTER TABLE users\n ADD COLUMN id2\n BIGSERIAL", rewrite: true},
+		{sql: "ALTER TABLE users ADD COLUMN email TEXT, ALTER COLUMN id TYPE BIGINT", rewrite: true},
+		{sql: "-- migrator:env prod\nALTER TABLE users ALTER COLUMN id TYPE BIGINT", rewrite: true},
+		{sql: "ALTER TABLE users ADD COLUMN email TEXT"},
+		{sql: "ALTER TABLE users ADD COLUMN created_at TIMESTAMPTZ DEFAULT now()"},
+		{sql: "ALTER TABLE users ALTER COLUMN email SET DEFAULT ''"},
+		{sql: "ALTER TABLE users ADD COLUMN kind TEXT /* type code */"},
+		{sql: "ALTER TABLE type ADD COLUMN c int"},
+		{sql: "CREATE TABLE users (id SERIAL PRIMARY KEY)"},
+	}
+	for _, tt := range tests {
+		if got := rewriteReason(tt.sql) != ""; got != tt.rewrite {
+			t.Fatalf("rewriteReason(%q) rewrite = %v, want %v", tt.sql, got, tt.rewrite)
+		}
+	}
+}
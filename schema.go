@@ -0,0 +1,182 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DumpSchema returns a textual description of the tables, views, columns,
+// constraints and indexes in the current schema, excluding the migrations
+// table and its lock table. Objects are sorted by name so the output is
+// deterministic and suitable for comparing against a checked-in golden file.
+func (m *Migrator) DumpSchema(ctx context.Context) (string, error) {
+	tx, err := m.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var schema string
+	if err := tx.QueryRowContext(ctx, `SELECT current_schema()`).Scan(&schema); err != nil {
+		return "", fmt.Errorf("failed to get current schema: %w", err)
+	}
+
+	var tables []string
+	kinds := make(map[string]string)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT c.relname, CASE c.relkind WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized view' ELSE 'table' END
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema()
+		AND c.relkind IN ('r', 'p', 'v', 'm')`)
+	if err != nil {
+		return "", fmt.Errorf("failed to list tables: %w", err)
+	}
+	for rows.Next() {
+		var name, kind string
+		if err := rows.Scan(&name, &kind); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("failed to list tables: %w", err)
+		}
+		if name == unqualifiedName(m.cfg.tableName) || (m.cfg.rowLevelLock && name == unqualifiedName(m.cfg.tableName+"_lock")) {
+			continue
+		}
+		tables = append(tables, name)
+		kinds[name] = kind
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	objects := make(map[string][]string)
+	queries := []struct {
+		kind  string
+		query string
+	}{
+		{
+			kind: "column",
+			query: `
+				SELECT c.relname,
+					a.attname || ' ' || format_type(a.atttypid, a.atttypmod)
+						|| CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+						|| COALESCE(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')
+				FROM pg_attribute a
+				JOIN pg_class c ON c.oid = a.attrelid
+				JOIN pg_namespace n ON n.oid = c.relnamespace
+				LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+				WHERE n.nspname = current_schema()
+				AND c.relkind IN ('r', 'p', 'v', 'm')
+				AND a.attnum > 0
+				AND NOT a.attisdropped`,
+		},
+		{
+			kind: "constraint",
+			query: `
+				SELECT c.relname, con.conname || ' ' || pg_get_constraintdef(con.oid)
+				FROM pg_constraint con
+				JOIN pg_class c ON c.oid = con.conrelid
+				JOIN pg_namespace n ON n.oid = c.relnamespace
+				WHERE n.nspname = current_schema()
+				AND con.contype IN ('c', 'f', 'p', 'u', 'x')`,
+		},
+		{
+			kind: "index",
+			query: `
+				SELECT tablename, indexdef
+				FROM pg_indexes
+				WHERE schemaname = current_schema()`,
+		},
+	}
+
+	for _, q := range queries {
+		rows, err := tx.QueryContext(ctx, q.query)
+		if err != nil {
+			return "", fmt.Errorf("failed to list %ss: %w", q.kind, err)
+		}
+		for rows.Next() {
+			var table, def string
+			if err := rows.Scan(&table, &def); err != nil {
+				rows.Close()
+				return "", fmt.Errorf("failed to list %ss: %w", q.kind, err)
+			}
+			// Index definitions are always schema-qualified; strip the
+			// current schema so dumps compare equal across schemas.
+			def = strings.ReplaceAll(def, " ON "+schema+".", " ON ")
+			objects[table] = append(objects[table], q.kind+" "+def)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return "", fmt.Errorf("failed to list %ss: %w", q.kind, err)
+		}
+	}
+
+	sort.Strings(tables)
+	var b strings.Builder
+	for _, table := range tables {
+		fmt.Fprintf(&b, "%s %s\n", kinds[table], table)
+		defs := objects[table]
+		sort.Strings(defs)
+		for _, def := range defs {
+			fmt.Fprintf(&b, "  %s\n", def)
+		}
+	}
+	return b.String(), nil
+}
+
+// AssertSchema compares the current schema, as described by DumpSchema, with
+// the golden dump stored in the file name of fsys. It fails with a readable
+// diff when they differ, e.g. because of manual changes that bypassed the
+// migrations. Lines prefixed with "-" are expected but missing, lines
+// prefixed with "+" exist but are not expected; each names its table.
+func (m *Migrator) AssertSchema(ctx context.Context, fsys fs.FS, name string) error {
+	expected, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("failed to read expected schema: %w", err)
+	}
+	actual, err := m.DumpSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	want, got := schemaLines(string(expected)), schemaLines(actual)
+	var diff []string
+	for _, line := range want {
+		if !slices.Contains(got, line) {
+			diff = append(diff, "- "+line)
+		}
+	}
+	for _, line := range got {
+		if !slices.Contains(want, line) {
+			diff = append(diff, "+ "+line)
+		}
+	}
+	if len(diff) > 0 {
+		return fmt.Errorf("schema does not match %s:\n%s", name, strings.Join(diff, "\n"))
+	}
+	return nil
+}
+
+// schemaLines flattens a schema dump into one line per object, qualifying
+// each column, constraint and index with its table so differences can be
+// reported on their own.
+func schemaLines(dump string) []string {
+	var lines []string
+	var table string
+	for _, line := range strings.Split(dump, "\n") {
+		switch {
+		case strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, "  "):
+			lines = append(lines, table+": "+strings.TrimSpace(line))
+		default:
+			table = strings.TrimSpace(line)
+			lines = append(lines, table)
+		}
+	}
+	return lines
+}
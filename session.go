@@ -0,0 +1,56 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// settingPattern matches run-time parameter names, including custom
+// parameters qualified with a prefix such as "app.tenant".
+var settingPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// validateSessionSettings checks the names passed to WithSessionSettings.
+func validateSessionSettings(settings map[string]string) error {
+	for name := range settings {
+		if !settingPattern.MatchString(name) {
+			return fmt.Errorf("invalid session setting %q: names must match %s", name, settingPattern)
+		}
+	}
+	return nil
+}
+
+// sortedSettings returns the names of the session settings in a stable
+// order, so they are applied in the same order on every run.
+func (m *Migrator) sortedSettings() []string {
+	names := make([]string, 0, len(m.cfg.sessionSettings))
+	for name := range m.cfg.sessionSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setSessionSettings applies the WithSessionSettings parameters for the rest
+// of the transaction.
+func (m *Migrator) setSessionSettings(ctx context.Context, tx *sql.Tx) error {
+	for _, name := range m.sortedSettings() {
+		if _, err := tx.ExecContext(ctx, `SELECT set_config($1, $2, true)`, name, m.cfg.sessionSettings[name]); err != nil {
+			return fmt.Errorf("failed to set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sessionSettingsSQL returns the SET LOCAL statements equivalent to
+// setSessionSettings, for GenerateSQL.
+func (m *Migrator) sessionSettingsSQL() string {
+	var b strings.Builder
+	for _, name := range m.sortedSettings() {
+		fmt.Fprintf(&b, "SET LOCAL %s TO %s;\n", name, quoteLiteral(m.cfg.sessionSettings[name]))
+	}
+	return b.String()
+}
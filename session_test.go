@@ -0,0 +1,30 @@
+package migrator
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidateSessionSettings(t *testing.T) {
+	valid := map[string]string{"timezone": "UTC", "client_encoding": "UTF8", "app.tenant": "acme"}
+	if err := validateSessionSettings(valid); err != nil {
+		t.Fatalf("expected valid settings, got %v", err)
+	}
+	for _, name := range []string{"", "time zone", "a.b.c", "timezone; RESET ALL"} {
+		if err := validateSessionSettings(map[string]string{name: "x"}); err == nil {
+			t.Fatalf("expected error for setting %q", name)
+		}
+	}
+}
+
+func TestSessionSettingsSQL(t *testing.T) {
+	m := newFileMigrator(t, fstest.MapFS{}, WithSessionSettings(map[string]string{
+		"timezone":        "UTC",
+		"client_encoding": "UTF8",
+		"app.note":        "it's",
+	}))
+	want := "SET LOCAL app.note TO 'it''s';\nSET LOCAL client_encoding TO 'UTF8';\nSET LOCAL timezone TO 'UTC';\n"
+	if got := m.sessionSettingsSQL(); got != want {
+		t.Fatalf("sessionSettingsSQL() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// errShadowRollback rolls back the transaction of VerifyAgainst.
+var errShadowRollback = errors.New("shadow run rolled back")
+
+// VerifyAgainst applies the pending migrations to shadowDB, typically a clone
+// of production, and returns the error a Run would fail with. The database
+// the Migrator was created with is not touched, and the shadow transaction is
+// always rolled back, so the same clone can verify several times. Call it as
+// a gate before Run on production.
+//
+// It cannot be used with a custom Tracker, which would record the shadow
+// run outside the shadow database.
+func (m *Migrator) VerifyAgainst(ctx context.Context, shadowDB *sql.DB) error {
+	if m.cfg.tracker != nil {
+		return errors.New("cannot verify against a shadow database with a custom tracker")
+	}
+
+	shadow := *m
+	shadow.db = shadowDB
+	err := shadow.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := shadow.run(ctx, tx, &runReport{}); err != nil {
+			return err
+		}
+		return errShadowRollback
+	})
+	if errors.Is(err, errShadowRollback) {
+		return nil
+	}
+	return err
+}
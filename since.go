@@ -0,0 +1,59 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// timestampLayout is the layout of timestamp version prefixes, e.g.
+// "20240131120000_add_users.sql".
+const timestampLayout = "20060102150405"
+
+// RunSince applies, in order, the pending migrations whose timestamp version
+// is at or after since. Earlier pending migrations are left unapplied. It
+// requires the Lexical scheme with every file name starting with a
+// YYYYMMDDHHMMSS timestamp in UTC, and errors otherwise.
+func (m *Migrator) RunSince(ctx context.Context, since time.Time) error {
+	if m.cfg.scheme != Lexical {
+		return errors.New("RunSince requires timestamp versions with the Lexical scheme")
+	}
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return fmt.Errorf("failed to get migration files: %w", err)
+	}
+	for _, file := range files {
+		if _, err := versionTimestamp(file); err != nil {
+			return err
+		}
+	}
+
+	cutoff := *m
+	cutoff.since = since
+	return cutoff.Run(ctx)
+}
+
+// versionTimestamp parses the timestamp prefix of a migration file name.
+func versionTimestamp(file string) (time.Time, error) {
+	if len(file) < len(timestampLayout) {
+		return time.Time{}, fmt.Errorf("migration %s has no timestamp version", file)
+	}
+	ts, err := time.Parse(timestampLayout, file[:len(timestampLayout)])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("migration %s has no timestamp version: %w", file, err)
+	}
+	if rest := file[len(timestampLayout):]; rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+		return time.Time{}, fmt.Errorf("migration %s has no timestamp version", file)
+	}
+	return ts, nil
+}
+
+// beforeSince reports whether a migration predates the RunSince cutoff.
+func (m *Migrator) beforeSince(file string) bool {
+	if m.since.IsZero() {
+		return false
+	}
+	ts, err := versionTimestamp(file)
+	return err == nil && ts.Before(m.since)
+}
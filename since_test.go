@@ -0,0 +1,47 @@
+package migrator
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestVersionTimestamp(t *testing.T) {
+	tests := []struct {
+		file    string
+		want    time.Time
+		wantErr bool
+	}{
+		{file: "20240131120000_add_users.sql", want: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{file: "20240131120000.sql", want: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{file: "001_add_users.sql", wantErr: true},
+		{file: "202401311200001_add_users.sql", wantErr: true},
+		{file: "20241331120000_add_users.sql", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := versionTimestamp(tt.file)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("versionTimestamp(%q) error = %v, wantErr %v", tt.file, err, tt.wantErr)
+		}
+		if !got.Equal(tt.want) {
+			t.Fatalf("versionTimestamp(%q) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestRunSinceRequiresTimestampVersions(t *testing.T) {
+	m := newFileMigrator(t, fstest.MapFS{
+		"001_create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+	})
+	if err := m.RunSince(context.Background(), time.Now()); err == nil {
+		t.Fatal("expected error for non-timestamp versions")
+	}
+
+	m = newFileMigrator(t, fstest.MapFS{
+		"V1__create_users.sql": &fstest.MapFile{Data: []byte(`CREATE TABLE users (id INT);`)},
+	}, WithVersionScheme(Flyway))
+	if err := m.RunSince(context.Background(), time.Now()); err == nil {
+		t.Fatal("expected error for the Flyway scheme")
+	}
+}
@@ -0,0 +1,323 @@
+package migrator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SyntaxError reports a migration file that cannot be split into statements,
+// such as one truncated inside a dollar-quoted body.
+type SyntaxError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// statement is a single SQL statement read from a migration file.
+type statement struct {
+	sql    string
+	line   int // line of the statement's first character within the file
+	column int // column of the statement's first character, in characters
+}
+
+// statementScanner splits SQL read from r into statements on top-level
+// semicolons. Semicolons inside quoted strings, quoted identifiers,
+// dollar-quoted bodies and comments do not end a statement.
+type statementScanner struct {
+	file   string
+	r      *bufio.Reader
+	line   int
+	column int // characters read on the current line
+}
+
+func newStatementScanner(file string, r io.Reader) *statementScanner {
+	return &statementScanner{file: file, r: bufio.NewReader(r), line: 1}
+}
+
+// splitStatements returns every statement in content.
+func splitStatements(file, content string) ([]statement, error) {
+	s := newStatementScanner(file, strings.NewReader(content))
+	var stmts []statement
+	for {
+		stmt, err := s.next()
+		if err == io.EOF {
+			return stmts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+}
+
+// next returns the next non-empty statement, or io.EOF when the input is
+// exhausted. Statements consisting only of comments are skipped.
+func (s *statementScanner) next() (statement, error) {
+	var (
+		buf         strings.Builder
+		hasCode     bool
+		startLine   = s.line
+		startColumn = s.column + 1
+		prev        byte
+		prev2       byte
+	)
+
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			if !hasCode {
+				return statement{}, io.EOF
+			}
+			return s.finish(buf.String(), startLine, startColumn), nil
+		}
+		if err != nil {
+			return statement{}, err
+		}
+
+		switch {
+		case c == ';':
+			if hasCode {
+				return s.finish(buf.String(), startLine, startColumn), nil
+			}
+			buf.Reset()
+			startLine, startColumn = s.line, s.column+1
+			prev, prev2 = 0, 0
+			continue
+		case c == '-' && s.peek() == '-':
+			buf.WriteByte(c)
+			if err := s.readLineComment(&buf); err != nil {
+				return statement{}, err
+			}
+		case c == '/' && s.peek() == '*':
+			buf.WriteByte(c)
+			if err := s.readBlockComment(&buf); err != nil {
+				return statement{}, err
+			}
+		case c == '\'':
+			buf.WriteByte(c)
+			escapes := (prev == 'E' || prev == 'e') && !isIdentByte(prev2)
+			if err := s.readQuoted(&buf, '\'', escapes); err != nil {
+				return statement{}, err
+			}
+			hasCode = true
+		case c == '"':
+			buf.WriteByte(c)
+			if err := s.readQuoted(&buf, '"', false); err != nil {
+				return statement{}, err
+			}
+			hasCode = true
+		case c == '$' && !isIdentByte(prev):
+			buf.WriteByte(c)
+			if err := s.readDollarQuoted(&buf); err != nil {
+				return statement{}, err
+			}
+			hasCode = true
+		default:
+			buf.WriteByte(c)
+			if !isSpace(c) {
+				hasCode = true
+			}
+		}
+		prev2, prev = prev, c
+	}
+}
+
+// finish trims the statement text, advancing its start position past any
+// leading whitespace.
+func (s *statementScanner) finish(text string, startLine, startColumn int) statement {
+	trimmed := strings.TrimLeft(text, " \t\r\n\f")
+	leading := text[:len(text)-len(trimmed)]
+	if n := strings.Count(leading, "\n"); n > 0 {
+		startLine += n
+		startColumn = 1
+		leading = leading[strings.LastIndexByte(leading, '\n')+1:]
+	}
+	startColumn += len(leading)
+	return statement{sql: strings.TrimRight(trimmed, " \t\r\n\f"), line: startLine, column: startColumn}
+}
+
+// position translates a 1-based character offset within the statement, as
+// reported by PostgreSQL, into a line and column within the file.
+func (stmt statement) position(offset int) (line, column int) {
+	line, column = stmt.line, stmt.column
+	for i, r := range []rune(stmt.sql) {
+		if i >= offset-1 {
+			break
+		}
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+func (s *statementScanner) readLineComment(buf *strings.Builder) error {
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		if c == '\n' {
+			return nil
+		}
+	}
+}
+
+// readBlockComment consumes a block comment after its opening slash. Block
+// comments nest in PostgreSQL.
+func (s *statementScanner) readBlockComment(buf *strings.Builder) error {
+	startLine := s.line
+	c, _ := s.readByte()
+	buf.WriteByte(c)
+
+	depth := 1
+	var prev byte
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			return s.errorf(startLine, "unclosed block comment")
+		}
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		switch {
+		case prev == '/' && c == '*':
+			depth++
+			c = 0
+		case prev == '*' && c == '/':
+			depth--
+			if depth == 0 {
+				return nil
+			}
+			c = 0
+		}
+		prev = c
+	}
+}
+
+// readQuoted consumes a quoted string or identifier after its opening quote.
+// A doubled quote is an escaped quote; backslash escapes apply only to
+// E'...' strings.
+func (s *statementScanner) readQuoted(buf *strings.Builder, quote byte, escapes bool) error {
+	startLine := s.line
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			if quote == '"' {
+				return s.errorf(startLine, "unterminated quoted identifier")
+			}
+			return s.errorf(startLine, "unterminated quoted string")
+		}
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		switch {
+		case escapes && c == '\\':
+			next, err := s.readByte()
+			if err == io.EOF {
+				return s.errorf(startLine, "unterminated quoted string")
+			}
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(next)
+		case c == quote:
+			if s.peek() != quote {
+				return nil
+			}
+			next, _ := s.readByte()
+			buf.WriteByte(next)
+		}
+	}
+}
+
+// readDollarQuoted consumes a dollar-quoted body such as $$...$$ or
+// $fn$...$fn$ after its opening dollar sign. A dollar sign that does not
+// start a tag, such as a $1 parameter, is consumed as ordinary text.
+func (s *statementScanner) readDollarQuoted(buf *strings.Builder) error {
+	startLine := s.line
+	var tag strings.Builder
+	tag.WriteByte('$')
+	for {
+		c := s.peek()
+		if c == '$' {
+			s.readByte()
+			tag.WriteByte(c)
+			break
+		}
+		if !isIdentByte(c) || (tag.Len() == 1 && c >= '0' && c <= '9') {
+			buf.WriteString(tag.String()[1:])
+			return nil
+		}
+		s.readByte()
+		tag.WriteByte(c)
+	}
+
+	delim := tag.String()
+	buf.WriteString(delim[1:])
+
+	var body strings.Builder
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			return s.errorf(startLine, "unterminated dollar-quoted string %s", delim)
+		}
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(c)
+		body.WriteByte(c)
+		if c == '$' && strings.HasSuffix(body.String(), delim) {
+			return nil
+		}
+	}
+}
+
+func (s *statementScanner) readByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	switch {
+	case err != nil:
+	case c == '\n':
+		s.line++
+		s.column = 0
+	case c&0xC0 != 0x80: // not a UTF-8 continuation byte
+		s.column++
+	}
+	return c, err
+}
+
+// peek returns the next byte without consuming it, or 0 at end of input.
+func (s *statementScanner) peek() byte {
+	b, err := s.r.Peek(1)
+	if err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (s *statementScanner) errorf(line int, format string, args ...any) error {
+	return &SyntaxError{File: s.file, Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,104 @@
+package migrator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Run("splits on top-level semicolons", func(t *testing.T) {
+		content := `-- create the table
+CREATE TABLE t (id INT, body TEXT DEFAULT 'a;b');
+
+/* block; comment */
+INSERT INTO "odd;name" VALUES (1, E'it\'s; fine');
+
+CREATE FUNCTION f() RETURNS INT AS $$
+BEGIN
+	RETURN 1;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION g() RETURNS INT AS $body$ SELECT $1; $body$ LANGUAGE sql;
+-- trailing comment`
+
+		stmts, err := splitStatements("001_test.sql", content)
+		if err != nil {
+			t.Fatalf("failed to split statements: %v", err)
+		}
+
+		expected := []statement{
+			{sql: "-- create the table\nCREATE TABLE t (id INT, body TEXT DEFAULT 'a;b')", line: 1, column: 1},
+			{sql: "/* block; comment */\nINSERT INTO \"odd;name\" VALUES (1, E'it\\'s; fine')", line: 4, column: 1},
+			{sql: "CREATE FUNCTION f() RETURNS INT AS $$\nBEGIN\n\tRETURN 1;\nEND;\n$$ LANGUAGE plpgsql", line: 7, column: 1},
+			{sql: "CREATE FUNCTION g() RETURNS INT AS $body$ SELECT $1; $body$ LANGUAGE sql", line: 13, column: 1},
+		}
+		if len(stmts) != len(expected) {
+			t.Fatalf("expected %d statements, got %d: %q", len(expected), len(stmts), stmts)
+		}
+		for i, stmt := range stmts {
+			if stmt != expected[i] {
+				t.Fatalf("statement %d: expected %q at %d:%d, got %q at %d:%d", i, expected[i].sql, expected[i].line, expected[i].column, stmt.sql, stmt.line, stmt.column)
+			}
+		}
+	})
+
+	t.Run("detects unterminated constructs", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			content string
+			line    int
+		}{
+			{
+				name:    "dollar quote",
+				content: "CREATE TABLE t (id INT);\nCREATE FUNCTION f() RETURNS INT AS $$\nBEGIN\n\tRETURN 1;\n",
+				line:    2,
+			},
+			{
+				name:    "block comment",
+				content: "CREATE TABLE t (id INT);\n\n/* unfinished\ncomment",
+				line:    3,
+			},
+			{
+				name:    "quoted string",
+				content: "INSERT INTO t VALUES ('oops);",
+				line:    1,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := splitStatements("001_truncated.sql", tt.content)
+
+				var syntaxErr *SyntaxError
+				if !errors.As(err, &syntaxErr) {
+					t.Fatalf("expected SyntaxError, got %v", err)
+				}
+				if syntaxErr.File != "001_truncated.sql" {
+					t.Fatalf("expected file 001_truncated.sql, got %s", syntaxErr.File)
+				}
+				if syntaxErr.Line != tt.line {
+					t.Fatalf("expected line %d, got %d", tt.line, syntaxErr.Line)
+				}
+			})
+		}
+	})
+}
+
+func TestStatementPosition(t *testing.T) {
+	content := "SELECT 1; SELECT\n  2,\n  bad syntax;"
+	stmts, err := splitStatements("001_test.sql", content)
+	if err != nil {
+		t.Fatalf("failed to split statements: %v", err)
+	}
+	stmt := stmts[1]
+	if stmt.line != 1 || stmt.column != 11 {
+		t.Fatalf("expected statement at 1:11, got %d:%d", stmt.line, stmt.column)
+	}
+
+	// PostgreSQL reports "syntax" as character 19 of the statement.
+	line, column := stmt.position(19)
+	if line != 3 || column != 7 {
+		t.Fatalf("expected position 3:7, got %d:%d", line, column)
+	}
+}
@@ -0,0 +1,108 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// state is the document produced by ExportState.
+type state struct {
+	Migrations []stateRecord `json:"migrations"`
+}
+
+type stateRecord struct {
+	Version   string    `json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+	AppliedBy string    `json:"applied_by,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Release   string    `json:"release,omitempty"`
+	Skipped   bool      `json:"skipped,omitempty"`
+}
+
+// ExportState serializes the recorded migrations as JSON, in migration
+// order, so they can be restored on another database with ImportState, e.g.
+// the green side of a blue/green cutover whose data is replicated
+// separately. Recorded versions without a migration file are left out, as
+// ImportState would reject them.
+func (m *Migrator) ExportState(ctx context.Context) ([]byte, error) {
+	files, applied, err := m.readState(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	s := state{Migrations: []stateRecord{}}
+	for _, file := range files {
+		status, ok := applied[versionOf(file)]
+		if !ok {
+			continue
+		}
+		s.Migrations = append(s.Migrations, stateRecord{
+			Version:   status.Version,
+			AppliedAt: status.AppliedAt,
+			AppliedBy: status.AppliedBy,
+			Checksum:  status.Checksum,
+			Release:   status.Release,
+			Skipped:   status.Skipped,
+		})
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state: %w", err)
+	}
+	return data, nil
+}
+
+// ImportState records the migrations serialized by ExportState as applied,
+// without running their SQL, keeping their original timestamps, deploy
+// identifiers and checksums. Every version must match a migration file.
+// Already recorded versions are skipped, so importing is idempotent.
+func (m *Migrator) ImportState(ctx context.Context, data []byte) error {
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("failed to decode state: %w", err)
+	}
+
+	return m.withLockedTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		applied, err := m.getAppliedMigrations(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("failed to get applied migrations: %w", err)
+		}
+
+		files, err := m.getMigrationFiles()
+		if err != nil {
+			return fmt.Errorf("failed to get migration files: %w", err)
+		}
+		present := make(map[string]string, len(files))
+		for _, file := range files {
+			present[versionOf(file)] = file
+		}
+
+		for _, record := range s.Migrations {
+			file, ok := present[record.Version]
+			if !ok {
+				return fmt.Errorf("migration %s not found", record.Version)
+			}
+			if applied[record.Version] {
+				continue
+			}
+			if err := m.markApplied(ctx, tx, Record{
+				Version:   record.Version,
+				Checksum:  record.Checksum,
+				AppliedBy: record.AppliedBy,
+				AppliedAt: record.AppliedAt,
+				Release:   record.Release,
+				Path:      file,
+				Skipped:   record.Skipped,
+			}); err != nil {
+				return fmt.Errorf("failed to record migration %s: %w", record.Version, err)
+			}
+			applied[record.Version] = true
+			m.cfg.logger.Info("imported migration", "version", record.Version)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,230 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MigrationStatus describes a migration file and whether it has been applied.
+type MigrationStatus struct {
+	Version string
+	Applied bool
+	// AppliedAt is when the migration was applied, in UTC. Without
+	// WithTimestampTZ it is the wall-clock time of the applying session.
+	AppliedAt time.Time
+	AppliedBy string
+	Checksum  string
+	// ServerVersion is the PostgreSQL server_version the migration was
+	// applied against. It is empty for migrations recorded before it was
+	// tracked.
+	ServerVersion string
+	// Release is the release named by the migration's release directive.
+	Release string
+	// Path is the migration file that produced the applied version. It is
+	// empty for migrations recorded before it was tracked.
+	Path string
+	// Skipped reports that the migration was recorded without running:
+	// its skip-if predicate held, its env directive excluded the
+	// environment, or Supersede replaced it.
+	Skipped bool
+}
+
+// Status reports every migration file in order along with whether it has
+// been applied to the database.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	return m.StatusWith(ctx, m.db)
+}
+
+// StatusWith is like Status but reads the migrations table from db instead of
+// the database the Migrator was created with, e.g. a read replica. It never
+// writes: the reads run in a read-only transaction and a missing migrations
+// table is reported as every migration pending.
+func (m *Migrator) StatusWith(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	files, applied, err := m.readState(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(files))
+	for _, file := range files {
+		version := versionOf(file)
+		status, ok := applied[version]
+		if !ok {
+			status = MigrationStatus{Version: version}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// readState returns the ordered migration files and the applied migrations
+// recorded in db, reading within a read-only transaction.
+func (m *Migrator) readState(ctx context.Context, db *sql.DB) ([]string, map[string]MigrationStatus, error) {
+	files, applied, _, err := m.readStateWithRenames(ctx, db)
+	return files, applied, err
+}
+
+// readStateWithRenames is like readState but also returns the files renamed
+// since they were applied, mapped to their recorded versions. Each renamed
+// file is reported as applied under its new version.
+func (m *Migrator) readStateWithRenames(ctx context.Context, db *sql.DB) ([]string, map[string]MigrationStatus, map[string]string, error) {
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get migration files: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	applied, err := m.getAppliedRecords(ctx, tx)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	renamed, err := renamedVersions(m.cfg.scheme, files, applied)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := m.verifyRenames(ctx, tx, files, renamed); err != nil {
+		return nil, nil, nil, err
+	}
+	for version, old := range renamed {
+		status := applied[old]
+		status.Version = version
+		applied[version] = status
+	}
+	return files, applied, renamed, nil
+}
+
+// getAppliedRecords reads the migrations table, returning an empty result
+// rather than creating the table when it does not exist. With a custom
+// tracker only the applied versions are known.
+func (m *Migrator) getAppliedRecords(ctx context.Context, tx *sql.Tx) (map[string]MigrationStatus, error) {
+	if m.cfg.tracker != nil {
+		versions, err := m.cfg.tracker.AppliedVersions(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		applied := make(map[string]MigrationStatus, len(versions))
+		for _, version := range versions {
+			applied[version] = MigrationStatus{Version: version, Applied: true}
+		}
+		return applied, nil
+	}
+
+	exists, err := m.tableExists(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]MigrationStatus)
+	if !exists {
+		return applied, nil
+	}
+
+	// Columns added after the first release may be missing until the next
+	// Run upgrades the table, e.g. when a replica checks EnsureMigrated
+	// during a rolling deploy, so they are read as their defaults.
+	columns, err := m.tableColumns(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	selected := []string{"version", "applied_at"}
+	for _, column := range []struct{ name, missing string }{
+		{"applied_by", "NULL"},
+		{"checksum", "NULL"},
+		{"skipped", "FALSE"},
+		{"server_version", "NULL"},
+		{"release", "NULL"},
+		{"path", "NULL"},
+	} {
+		if _, ok := columns[column.name]; ok {
+			selected = append(selected, column.name)
+		} else {
+			selected = append(selected, column.missing)
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selected, ", "), m.table)
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version   string
+			appliedAt sql.NullTime
+			appliedBy sql.NullString
+			checksum  sql.NullString
+			skipped   bool
+			server    sql.NullString
+			release   sql.NullString
+			path      sql.NullString
+		)
+		if err := rows.Scan(&version, &appliedAt, &appliedBy, &checksum, &skipped, &server, &release, &path); err != nil {
+			return nil, err
+		}
+		applied[version] = MigrationStatus{
+			Version:   version,
+			Applied:   true,
+			AppliedAt: appliedAt.Time.UTC(),
+			AppliedBy: appliedBy.String,
+			Checksum:  checksum.String,
+			Skipped:   skipped,
+
+			ServerVersion: server.String,
+			Release:       release.String,
+			Path:          path.String,
+		}
+	}
+
+	return applied, rows.Err()
+}
+
+// EnsureMigrated returns a *PendingMigrationsError, which matches
+// ErrPendingMigrations, if any migration has not been applied or recorded as
+// skipped. Unlike Run it never applies anything, for applications that are
+// migrated by a separate job.
+func (m *Migrator) EnsureMigrated(ctx context.Context) error {
+	files, applied, err := m.readState(ctx, m.db)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, file := range files {
+		if version := versionOf(file); !applied[version].Applied {
+			pending = append(pending, version)
+		}
+	}
+
+	if len(pending) > 0 {
+		return &PendingMigrationsError{Versions: pending}
+	}
+	return nil
+}
+
+// IsApplied reports whether version has run against the database, e.g. to
+// enable a feature only once its migration has run. Migrations recorded as
+// skipped did not run and are reported as not applied, like unknown
+// versions. Like Status it reads without taking locks.
+func (m *Migrator) IsApplied(ctx context.Context, version string) (bool, error) {
+	_, applied, err := m.readState(ctx, m.db)
+	if err != nil {
+		return false, err
+	}
+	return applied[version].ran(), nil
+}
+
+// ran reports whether the migration was applied by running it, rather than
+// recorded as skipped.
+func (s MigrationStatus) ran() bool {
+	return s.Applied && !s.Skipped
+}
@@ -0,0 +1,34 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// tableSizes returns the total size of each WithTableSizeDelta table, or 0
+// for tables that do not exist.
+func (m *Migrator) tableSizes(ctx context.Context, tx *sql.Tx) ([]int64, error) {
+	sizes := make([]int64, len(m.cfg.sizeTables))
+	for i, table := range m.cfg.sizeTables {
+		if err := tx.QueryRowContext(ctx, `SELECT COALESCE(pg_total_relation_size(to_regclass($1)), 0)`, table).Scan(&sizes[i]); err != nil {
+			return nil, fmt.Errorf("failed to measure size of %s: %w", table, err)
+		}
+	}
+	return sizes, nil
+}
+
+// reportTableSizes measures the tables again after the migrations and adds
+// the change since before to the log and the report.
+func (m *Migrator) reportTableSizes(ctx context.Context, tx *sql.Tx, report *runReport, before []int64) error {
+	after, err := m.tableSizes(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for i, table := range m.cfg.sizeTables {
+		size := tableSize{Table: table, Before: before[i], After: after[i], Delta: after[i] - before[i]}
+		m.cfg.logger.Info("table size changed", "table", table, "before_bytes", size.Before, "after_bytes", size.After, "delta_bytes", size.Delta)
+		report.TableSizes = append(report.TableSizes, size)
+	}
+	return nil
+}
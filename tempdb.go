@@ -0,0 +1,53 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CreateTempDatabase creates a uniquely named database for an isolated test
+// run, e.g. "app_test_1712345678901234567" for the prefix "app_test". The
+// adminDB connection issues CREATE DATABASE, so its role needs the CREATEDB
+// privilege. open connects to the new database by name, since only the
+// caller knows the driver and connection string.
+//
+// cleanup closes the returned connection and drops the database.
+func CreateTempDatabase(ctx context.Context, adminDB *sql.DB, prefix string, open func(name string) (*sql.DB, error)) (db *sql.DB, cleanup func() error, err error) {
+	if !identifierPattern.MatchString(prefix) {
+		return nil, nil, fmt.Errorf("invalid database prefix %q: identifiers must match %s", prefix, identifierPattern)
+	}
+	name := fmt.Sprintf("%s_%d", strings.ToLower(prefix), time.Now().UnixNano())
+	if len(name) > maxIdentifierLength {
+		return nil, nil, fmt.Errorf("invalid database prefix %q: database name %s is longer than %d bytes", prefix, name, maxIdentifierLength)
+	}
+
+	if _, err := adminDB.ExecContext(ctx, "CREATE DATABASE "+quoteIdent(name)); err != nil {
+		return nil, nil, fmt.Errorf("failed to create database %s: %w", name, err)
+	}
+	drop := func() error {
+		// Not ctx: cleanup usually runs after the caller's context is done.
+		if _, err := adminDB.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+quoteIdent(name)); err != nil {
+			return fmt.Errorf("failed to drop database %s: %w", name, err)
+		}
+		return nil
+	}
+
+	db, err = open(name)
+	if err == nil {
+		err = db.PingContext(ctx)
+		if err != nil {
+			db.Close()
+		}
+	}
+	if err != nil {
+		return nil, nil, errors.Join(fmt.Errorf("failed to connect to database %s: %w", name, err), drop())
+	}
+
+	return db, func() error {
+		return errors.Join(db.Close(), drop())
+	}, nil
+}
@@ -0,0 +1,20 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestCreateTempDatabaseInvalidPrefix(t *testing.T) {
+	open := func(string) (*sql.DB, error) {
+		t.Fatal("expected no connection for an invalid prefix")
+		return nil, nil
+	}
+	for _, prefix := range []string{"", "app-test", "app; DROP DATABASE app", strings.Repeat("a", 50)} {
+		if _, _, err := CreateTempDatabase(context.Background(), nil, prefix, open); err == nil {
+			t.Fatalf("expected error for prefix %q", prefix)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package migrator
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// RunForTenant applies all pending migrations to the given tenant schema. The
+// schema is put first on the search_path for the migration transaction, ahead
+// of the connection's path, so unqualified names in the migrations resolve
+// inside it while extensions and functions in schemas such as public stay
+// visible. Unqualified names missing from the tenant schema therefore resolve
+// in the rest of the path. An unqualified migrations table is kept in the
+// tenant schema. Each tenant uses its own advisory lock derived from the
+// lock ID and the schema name, so migrations of different tenants run
+// concurrently while those of one tenant are serialized.
+//
+// The schema must already exist. A schema-qualified WithTableName is shared
+// by all tenants and defeats the isolation.
+func (m *Migrator) RunForTenant(ctx context.Context, schema string) error {
+	if !identifierPattern.MatchString(schema) {
+		return fmt.Errorf("invalid tenant schema %q: identifiers must match %s", schema, identifierPattern)
+	}
+	schema = strings.ToLower(schema)
+
+	tenant := *m
+	tenant.cfg.lockID = tenantLockID(m.cfg.lockID, schema)
+	tenant.searchPath = quoteIdent(schema)
+	if !strings.Contains(m.cfg.tableName, ".") {
+		// Qualified so a migrations table elsewhere on the path, e.g. in
+		// public, is not mistaken for the tenant's.
+		tenant.table = quoteIdent(schema) + "." + m.table
+		if m.lockTable != "" {
+			tenant.lockTable = quoteIdent(schema) + "." + m.lockTable
+		}
+	}
+	return tenant.Run(ctx)
+}
+
+// tenantLockID derives the advisory lock ID of a tenant from the base lock ID
+// with FNV-1a, so different tenants get independent locks.
+func tenantLockID(base int64, schema string) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, base)
+	h.Write([]byte(schema))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,26 @@
+package migrator
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Tracer starts spans around migration runs, so they can be exported to a
+// tracing system such as OpenTelemetry without this package depending on
+// it. Run starts a "migrator.run" span, and each migration it runs, including
+// one its skip-if directive skips, gets a child "migrator.migration" span
+// with a "version" attribute. Migrations left out by their env or type
+// directive get no span.
+type Tracer interface {
+	// Start begins a span named name as a child of any span in ctx. It
+	// returns the context carrying the new span and a function that ends
+	// the span, recording err if it is non-nil.
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(err error))
+}
+
+// noopTracer is the default Tracer.
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
@@ -0,0 +1,77 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Tracker records which migrations have been applied. The default tracker
+// stores them in the migrations table; a custom tracker set with WithTracker
+// can keep them elsewhere while migrations still run against the database.
+//
+// Each method receives the transaction the migrations run in. Trackers that
+// write to another store cannot join it, so a record may be kept for
+// migrations whose transaction later fails to commit.
+type Tracker interface {
+	// AppliedVersions returns the versions recorded as applied.
+	AppliedVersions(ctx context.Context, tx *sql.Tx) ([]string, error)
+	// MarkApplied records a migration as applied.
+	MarkApplied(ctx context.Context, tx *sql.Tx, record Record) error
+}
+
+// Record describes a migration being marked as applied.
+type Record struct {
+	Version   string
+	Checksum  string
+	AppliedBy string
+	// AppliedAt is when the migration was applied. It is zero for
+	// migrations applied now, and set when restoring state exported from
+	// another database.
+	AppliedAt time.Time
+	// Release is the release named by the migration's release directive.
+	Release string
+	// Path is the migration file the version was read from, relative to
+	// the migrations FS.
+	Path string
+	// Skipped reports that the migration was recorded without running.
+	Skipped bool
+}
+
+// tableTracker is the default Tracker, backed by the migrations table.
+type tableTracker struct {
+	table string // quoted table name
+}
+
+func (t *tableTracker) AppliedVersions(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	query := fmt.Sprintf("SELECT version FROM %s", t.table)
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+func (t *tableTracker) MarkApplied(ctx context.Context, tx *sql.Tx, record Record) error {
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO %s (version, applied_by, checksum, skipped, applied_at, release, path, server_version)
+		VALUES ($1, $2, $3, $4, COALESCE($5, CURRENT_TIMESTAMP), $6, $7, current_setting('server_version'))`, t.table)
+	appliedBy := sql.NullString{String: record.AppliedBy, Valid: record.AppliedBy != ""}
+	appliedAt := sql.NullTime{Time: record.AppliedAt, Valid: !record.AppliedAt.IsZero()}
+	release := sql.NullString{String: record.Release, Valid: record.Release != ""}
+	path := sql.NullString{String: record.Path, Valid: record.Path != ""}
+	_, err := tx.ExecContext(ctx, insertQuery, record.Version, appliedBy, record.Checksum, record.Skipped, appliedAt, release, path)
+	return err
+}
@@ -0,0 +1,36 @@
+package migrator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkTransactionControl returns an error for a statement that would begin,
+// commit or roll back the transaction the migrations run in. ROLLBACK TO
+// SAVEPOINT is allowed. END is not checked, since it also closes BEGIN
+// ATOMIC function bodies, which the splitter reads as separate statements.
+func checkTransactionControl(stmt statement) error {
+	words := strings.Fields(strings.ToUpper(sqlCommentPattern.ReplaceAllString(stmt.sql, " ")))
+	if len(words) == 0 {
+		return nil
+	}
+
+	keyword := words[0]
+	switch keyword {
+	case "BEGIN", "COMMIT", "ABORT":
+	case "START":
+		if len(words) < 2 || words[1] != "TRANSACTION" {
+			return nil
+		}
+		keyword = "START TRANSACTION"
+	case "ROLLBACK":
+		for _, word := range words[1:min(len(words), 3)] {
+			if word == "TO" {
+				return nil
+			}
+		}
+	default:
+		return nil
+	}
+	return fmt.Errorf("statement at line %d: %s is not allowed in a migration; all migrations already run in one transaction managed by the migrator", stmt.line, keyword)
+}
@@ -0,0 +1,36 @@
+package migrator
+
+import "testing"
+
+func TestCheckTransactionControl(t *testing.T) {
+	rejected := []string{
+		"BEGIN",
+		"begin transaction isolation level serializable",
+		"-- wrap it\nBEGIN WORK",
+		"START TRANSACTION",
+		"COMMIT",
+		"commit prepared 'x'",
+		"ROLLBACK",
+		"ABORT",
+	}
+	for _, sql := range rejected {
+		if err := checkTransactionControl(statement{sql: sql, line: 1}); err == nil {
+			t.Errorf("expected %q to be rejected", sql)
+		}
+	}
+
+	allowed := []string{
+		"ROLLBACK TO SAVEPOINT before_update",
+		"rollback work to before_update",
+		"SAVEPOINT before_update",
+		"CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql",
+		"END",
+		"START_DATE",
+		"SELECT 'BEGIN'",
+	}
+	for _, sql := range allowed {
+		if err := checkTransactionControl(statement{sql: sql, line: 1}); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", sql, err)
+		}
+	}
+}
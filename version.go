@@ -0,0 +1,253 @@
+package migrator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionScheme determines how migration file names are parsed and ordered.
+type VersionScheme int
+
+const (
+	// Lexical orders migration files alphabetically by name, e.g.
+	// "001_create_users.sql" before "002_add_email.sql". This is the default.
+	Lexical VersionScheme = iota
+
+	// Flyway parses Flyway-style names such as "V1.2.3__description.sql" and
+	// orders them numerically segment by segment, so V1.2 sorts before V1.10
+	// and before V1.2.1.
+	Flyway
+)
+
+// sortFiles orders migration file names according to the scheme. It returns
+// an error if a file name cannot be parsed by the scheme.
+func (s VersionScheme) sortFiles(files []string) error {
+	switch s {
+	case Lexical:
+		sort.Strings(files)
+		return nil
+	case Flyway:
+		return sortFlywayFiles(files)
+	default:
+		return fmt.Errorf("unknown version scheme %d", s)
+	}
+}
+
+func sortFlywayFiles(files []string) error {
+	segments := make(map[string][]uint64, len(files))
+	for _, file := range files {
+		segs, err := parseFlywayVersion(file)
+		if err != nil {
+			return err
+		}
+		segments[file] = segs
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if c := compareSegments(segments[files[i]], segments[files[j]]); c != 0 {
+			return c < 0
+		}
+		return files[i] < files[j]
+	})
+
+	for i := 1; i < len(files); i++ {
+		if compareSegments(segments[files[i-1]], segments[files[i]]) == 0 {
+			return fmt.Errorf("duplicate migration version in %s and %s", files[i-1], files[i])
+		}
+	}
+	return nil
+}
+
+// parseFlywayVersion parses the dotted version of a file named like
+// "V1.2.3__description.sql". Underscores are accepted as segment separators
+// as in Flyway, so "V1_2__description.sql" is version 1.2.
+func parseFlywayVersion(file string) ([]uint64, error) {
+	name := versionOf(file)
+	version, _, found := strings.Cut(strings.TrimPrefix(name, "V"), "__")
+	if !strings.HasPrefix(name, "V") || !found || version == "" {
+		return nil, fmt.Errorf("invalid flyway migration file name %q: want V<version>__<description>.sql", file)
+	}
+
+	parts := strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '_' })
+	segments := make([]uint64, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flyway migration file name %q: version segment %q is not a number", file, part)
+		}
+		segments = append(segments, n)
+	}
+	return segments, nil
+}
+
+// compareSegments compares two numeric versions segment by segment. When one
+// version is a prefix of the other, the shorter one sorts first.
+func compareSegments(a, b []uint64) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// NextVersion returns the version prefix for a new migration file: one more
+// than the highest version in the migrations, so gaps are never filled. With
+// the Lexical scheme it is the numeric file name prefix zero-padded to the
+// configured width, e.g. "004" after "003_add_posts.sql", or "001" when there
+// are no migrations. With the Flyway scheme it is the next major version,
+// e.g. "V2" after "V1.2__add_posts.sql".
+func (m *Migrator) NextVersion() (string, error) {
+	files, err := m.getMigrationFiles()
+	if err != nil {
+		return "", fmt.Errorf("failed to get migration files: %w", err)
+	}
+
+	var highest uint64
+	for _, file := range files {
+		n, ok, err := m.cfg.scheme.leadingNumber(file)
+		if err != nil {
+			return "", err
+		}
+		if ok && n > highest {
+			highest = n
+		}
+	}
+
+	switch m.cfg.scheme {
+	case Flyway:
+		return fmt.Sprintf("V%d", highest+1), nil
+	default:
+		return fmt.Sprintf("%0*d", m.cfg.versionWidth, highest+1), nil
+	}
+}
+
+// leadingNumber returns the leading numeric component of a file name: the
+// digit prefix for Lexical or the major version for Flyway. ok is false for
+// Lexical files without a digit prefix.
+func (s VersionScheme) leadingNumber(file string) (n uint64, ok bool, err error) {
+	if s == Flyway {
+		segments, err := parseFlywayVersion(file)
+		if err != nil || len(segments) == 0 {
+			return 0, false, err
+		}
+		return segments[0], true, nil
+	}
+
+	digits := strings.IndexFunc(file, func(r rune) bool { return r < '0' || r > '9' })
+	if digits <= 0 {
+		return 0, false, nil
+	}
+	n, err = strconv.ParseUint(file[:digits], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid version prefix in %s: %w", file, err)
+	}
+	return n, true, nil
+}
+
+// versionKey returns the numeric component of a version that identifies a
+// migration regardless of its description: the digit prefix for Lexical, e.g.
+// "1" for "001_create_users", or the dotted version for Flyway. ok is false
+// when the version has no numeric component.
+func (s VersionScheme) versionKey(version string) (key string, ok bool) {
+	if s == Flyway {
+		segments, err := parseFlywayVersion(version + ".sql")
+		if err != nil {
+			return "", false
+		}
+		parts := make([]string, len(segments))
+		for i, seg := range segments {
+			parts[i] = strconv.FormatUint(seg, 10)
+		}
+		return strings.Join(parts, "."), true
+	}
+
+	n, ok, err := s.leadingNumber(version)
+	if err != nil || !ok {
+		return "", false
+	}
+	return strconv.FormatUint(n, 10), true
+}
+
+// renamedVersions detects migration files renamed after they were applied.
+// It maps the version of each unapplied file to the applied version with the
+// same version key, provided no file still carries the applied version. It
+// returns an error when a key matches more than one such applied version or
+// unapplied file, since the rename cannot be told apart. The matches are only
+// candidates until verifyRenames has compared their checksums.
+func renamedVersions[V any](s VersionScheme, files []string, applied map[string]V) (map[string]string, error) {
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		present[versionOf(file)] = true
+	}
+
+	orphans := make(map[string][]string)
+	for version := range applied {
+		if present[version] {
+			continue
+		}
+		if key, ok := s.versionKey(version); ok {
+			orphans[key] = append(orphans[key], version)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil, nil
+	}
+
+	candidates := make(map[string][]string)
+	for _, file := range files {
+		version := versionOf(file)
+		if _, ok := applied[version]; ok {
+			continue
+		}
+		if key, ok := s.versionKey(version); ok && len(orphans[key]) > 0 {
+			candidates[key] = append(candidates[key], version)
+		}
+	}
+
+	renamed := make(map[string]string)
+	for key, versions := range candidates {
+		if len(orphans[key]) > 1 || len(versions) > 1 {
+			sort.Strings(orphans[key])
+			return nil, fmt.Errorf("ambiguous rename of applied migrations %s to %s: they share the version key %s",
+				strings.Join(orphans[key], ", "), strings.Join(versions, ", "), key)
+		}
+		renamed[versions[0]] = orphans[key][0]
+	}
+	return renamed, nil
+}
+
+// serverVersionNum converts a PostgreSQL version such as "14" or "14.2" to
+// the server_version_num form, e.g. 140002.
+func serverVersionNum(version string) (int, error) {
+	major, minor, _ := strings.Cut(version, ".")
+	maj, err := strconv.Atoi(major)
+	if err != nil || maj < 10 {
+		return 0, fmt.Errorf("invalid server version %q: want <major>[.<minor>] with major 10 or later", version)
+	}
+	var min int
+	if minor != "" {
+		if min, err = strconv.Atoi(minor); err != nil {
+			return 0, fmt.Errorf("invalid server version %q: want <major>[.<minor>] with major 10 or later", version)
+		}
+	}
+	return maj*10000 + min, nil
+}
+
+// checkMinServerVersion returns an error if current, a server_version_num,
+// is older than the required version.
+func checkMinServerVersion(current int, required string) error {
+	min, err := serverVersionNum(required)
+	if err != nil {
+		return err
+	}
+	if current < min {
+		return fmt.Errorf("server version %d.%d is older than the required %s", current/10000, current%10000, required)
+	}
+	return nil
+}
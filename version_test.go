@@ -0,0 +1,195 @@
+package migrator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFlywayVersionScheme(t *testing.T) {
+	t.Run("orders segments numerically", func(t *testing.T) {
+		files := []string{
+			"V1.10__ten.sql",
+			"V1.2.1__two_one.sql",
+			"V2__two.sql",
+			"V1.2__two.sql",
+			"V1_3__three.sql",
+		}
+		if err := Flyway.sortFiles(files); err != nil {
+			t.Fatalf("failed to sort files: %v", err)
+		}
+
+		expected := []string{
+			"V1.2__two.sql",
+			"V1.2.1__two_one.sql",
+			"V1_3__three.sql",
+			"V1.10__ten.sql",
+			"V2__two.sql",
+		}
+		if !slices.Equal(files, expected) {
+			t.Fatalf("expected %v, got %v", expected, files)
+		}
+	})
+
+	t.Run("rejects invalid names", func(t *testing.T) {
+		for _, file := range []string{
+			"001_create_users.sql",
+			"V1_create_users.sql",
+			"V__missing_version.sql",
+			"V1.x__bad_segment.sql",
+		} {
+			if err := Flyway.sortFiles([]string{file}); err == nil {
+				t.Errorf("expected error for %s, got nil", file)
+			}
+		}
+	})
+
+	t.Run("rejects duplicate versions", func(t *testing.T) {
+		files := []string{"V1.2__first.sql", "V1_2__second.sql"}
+		if err := Flyway.sortFiles(files); err == nil {
+			t.Fatal("expected error for duplicate versions, got nil")
+		}
+	})
+}
+
+func TestLexicalVersionScheme(t *testing.T) {
+	files := []string{"002_b.sql", "010_c.sql", "001_a.sql"}
+	if err := Lexical.sortFiles(files); err != nil {
+		t.Fatalf("failed to sort files: %v", err)
+	}
+
+	expected := []string{"001_a.sql", "002_b.sql", "010_c.sql"}
+	if !slices.Equal(files, expected) {
+		t.Fatalf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		opts     []Option
+		expected string
+	}{
+		{
+			name:     "empty set",
+			expected: "001",
+		},
+		{
+			name:     "sequential",
+			files:    []string{"001_a.sql", "002_b.sql", "003_c.sql"},
+			expected: "004",
+		},
+		{
+			name:     "gapped",
+			files:    []string{"001_a.sql", "005_b.sql"},
+			expected: "006",
+		},
+		{
+			name:     "custom width",
+			files:    []string{"0009_a.sql"},
+			opts:     []Option{WithVersionWidth(5)},
+			expected: "00010",
+		},
+		{
+			name:     "flyway",
+			files:    []string{"V1.2__a.sql", "V3.1__b.sql"},
+			opts:     []Option{WithVersionScheme(Flyway)},
+			expected: "V4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations := fstest.MapFS{}
+			for _, file := range tt.files {
+				migrations[file] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+
+			version, err := newFileMigrator(t, migrations, tt.opts...).NextVersion()
+			if err != nil {
+				t.Fatalf("failed to get next version: %v", err)
+			}
+			if version != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, version)
+			}
+		})
+	}
+}
+
+func TestRenamedVersions(t *testing.T) {
+	applied := map[string]bool{"001_old": true, "002_kept": true}
+	files := []string{"001_new.sql", "002_kept.sql", "003_next.sql"}
+	renamed, err := renamedVersions(Lexical, files, applied)
+	if err != nil {
+		t.Fatalf("failed to detect renames: %v", err)
+	}
+	if len(renamed) != 1 || renamed["001_new"] != "001_old" {
+		t.Fatalf("expected 001_new renamed from 001_old, got %v", renamed)
+	}
+
+	// Both files still exist, so neither is a rename.
+	files = []string{"001_old.sql", "001_other.sql"}
+	if renamed, err := renamedVersions(Lexical, files, applied); err != nil || len(renamed) != 0 {
+		t.Fatalf("expected no renames, got %v, %v", renamed, err)
+	}
+
+	applied = map[string]bool{"V1_2__old": true}
+	renamed, err = renamedVersions(Flyway, []string{"V1.2__new.sql"}, applied)
+	if err != nil || renamed["V1.2__new"] != "V1_2__old" {
+		t.Fatalf("expected V1.2__new renamed from V1_2__old, got %v, %v", renamed, err)
+	}
+}
+
+func TestRenamedVersionsAmbiguous(t *testing.T) {
+	tests := []struct {
+		name    string
+		applied map[string]bool
+		files   []string
+	}{
+		{"two applied versions", map[string]bool{"001_a": true, "01_b": true}, []string{"001_new.sql"}},
+		{"two renamed files", map[string]bool{"001_old": true}, []string{"001_a.sql", "01_b.sql"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := renamedVersions(Lexical, tt.files, tt.applied); err == nil || !strings.Contains(err.Error(), "ambiguous rename") {
+				t.Fatalf("expected an ambiguous rename error, got %v", err)
+			}
+		})
+	}
+
+	// Orphans sharing a key are harmless while no file claims them.
+	applied := map[string]bool{"001_a": true, "01_b": true}
+	if renamed, err := renamedVersions(Lexical, []string{"002_next.sql"}, applied); err != nil || len(renamed) != 0 {
+		t.Fatalf("expected no renames, got %v, %v", renamed, err)
+	}
+}
+
+func TestCheckMinServerVersion(t *testing.T) {
+	tests := []struct {
+		current  int
+		required string
+		wantErr  bool
+	}{
+		{current: 160002, required: "14"},
+		{current: 140002, required: "14.2"},
+		{current: 130010, required: "14", wantErr: true},
+		{current: 140001, required: "14.2", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := checkMinServerVersion(tt.current, tt.required)
+		if tt.wantErr && err == nil {
+			t.Errorf("expected server %d to be rejected for %s, got nil", tt.current, tt.required)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("expected server %d to satisfy %s, got %v", tt.current, tt.required, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "9.6", "fourteen", "14.x"} {
+		if _, err := serverVersionNum(invalid); err == nil {
+			t.Errorf("expected error for version %q, got nil", invalid)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Watch applies pending migrations, then polls the migrations every interval
+// and runs again whenever a file is added, removed or edited, until ctx is
+// done. It is meant for local development against os.DirFS, so migration
+// files apply soon after they are saved. A change is applied once the files
+// are unchanged for one interval, so a file saved in several writes is not
+// applied half-written. Each run takes the advisory lock and applies
+// migrations in order like Run; failures are logged and retried when the
+// files change again, including when the failed file is fixed in place.
+// Watch returns ctx.Err(), or an error if interval is not positive.
+func (m *Migrator) Watch(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("watch interval must be positive")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		applied string // fingerprint of the files of the last run
+		seen    string // fingerprint of the previous poll
+		started bool
+	)
+	for {
+		fingerprint, err := m.Fingerprint()
+		if err != nil {
+			m.cfg.logger.Error("failed to get migration files", "error", err)
+		} else if !started || (fingerprint != applied && fingerprint == seen) {
+			applied, started = fingerprint, true
+			if err := m.Run(ctx); err != nil && ctx.Err() == nil {
+				m.cfg.logger.Error("failed to run migrations", "error", err)
+			}
+		}
+		seen = fingerprint
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}